@@ -1,12 +1,12 @@
 package main
 
 import (
-	"cloud.google.com/go/firestore"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -28,6 +28,30 @@ var StartTime time.Time = time.Now()
 // DefaultPort is the default port number if no other port number is specified via the $PORT environment variable
 const DefaultPort int = 3000
 
+// Webhook stores selectable via the $WEBHOOK_STORE environment variable
+const (
+	// StoreFirestore keeps webhooks in firestore, and is the default
+	StoreFirestore string = "firestore"
+	// StoreMemory keeps webhooks in memory, and requires no credentials
+	StoreMemory string = "memory"
+	// StoreBolt keeps webhooks in a single file on disk, pointed to by $WEBHOOK_STORE_PATH
+	StoreBolt string = "bolt"
+)
+
+// DefaultStorePath is the default path of the database file used by the bolt webhook store
+const DefaultStorePath string = "webhooks.db"
+
+// Upstream data sources selectable via the $DATA_SOURCE environment variable
+const (
+	// SourceHTTP fetches data from the public apis, and is the default
+	SourceHTTP string = "http"
+	// SourceFixtures serves data from the fixture files in $FIXTURES_PATH, and requires no internet access
+	SourceFixtures string = "fixtures"
+)
+
+// DefaultFixturesPath is the default path of the directory containing the fixture files
+const DefaultFixturesPath string = "fixtures"
+
 // Functions
 // -------------------------------------------------------------------------------------------
 
@@ -40,6 +64,138 @@ func port() int {
 	return DefaultPort
 }
 
+// Create the webhook store selected by environment variable $WEBHOOK_STORE, or firestore if the variable is not set
+func webhookStore() notifications.WebhookStore {
+	switch store := os.Getenv("WEBHOOK_STORE"); store {
+	case "", StoreFirestore:
+		return notifications.NewFirestoreStore(fs.NewFirestoreClient())
+	case StoreMemory:
+		return notifications.NewMemoryStore()
+	case StoreBolt:
+		path := os.Getenv("WEBHOOK_STORE_PATH")
+		if path == "" {
+			path = DefaultStorePath
+		}
+		store, err := notifications.NewBoltStore(path)
+		if err != nil {
+			log.Fatalf("Failed to open webhook store at %s: %s", path, err.Error())
+		}
+		return store
+	default:
+		log.Fatalf("Unknown webhook store: %s", store)
+		return nil
+	}
+}
+
+// Create the upstream data providers selected by environment variable $DATA_SOURCE, or the public apis if the variable is not set
+func dataProviders() corona.Providers {
+	switch source := os.Getenv("DATA_SOURCE"); source {
+	case "", SourceHTTP:
+		return corona.NewHTTPProviders()
+	case SourceFixtures:
+		path := os.Getenv("FIXTURES_PATH")
+		if path == "" {
+			path = DefaultFixturesPath
+		}
+		fixtures, err := corona.LoadFixtures(path)
+		if err != nil {
+			log.Fatalf("Failed to load fixtures from %s: %s", path, err.Error())
+		}
+		return fixtures.Providers()
+	default:
+		log.Fatalf("Unknown data source: %s", source)
+		return corona.Providers{}
+	}
+}
+
+// Get a duration from environment variable `name`, or use `fallback` if the variable is not set or invalid.
+// Durations that are not positive are invalid, as they would make tickers and caches misbehave
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	if value := os.Getenv(name); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			log.Printf("Invalid duration in $%s, using %s: %s", name, fallback, err.Error())
+			return fallback
+		}
+		if d <= 0 {
+			log.Printf("Invalid positive duration in $%s, using %s", name, fallback)
+			return fallback
+		}
+		return d
+	}
+	return fallback
+}
+
+// Get an integer from environment variable `name`, or use `fallback` if the variable is not set, invalid or less than `least`
+func intFromEnv(name string, fallback, least int) int {
+	if value := os.Getenv(name); value != "" {
+		i, err := strconv.Atoi(value)
+		if err != nil || i < least {
+			log.Printf("Invalid integer in $%s, must be at least %d, using %d", name, least, fallback)
+			return fallback
+		}
+		return i
+	}
+	return fallback
+}
+
+// Get how long to cache upstream data from environment variables $CACHE_TTL_CASES, $CACHE_TTL_STRINGENCY and $CACHE_TTL_COUNTRIES
+func cacheTTLs() corona.CacheTTLs {
+	return corona.CacheTTLs{
+		Cases:      durationFromEnv("CACHE_TTL_CASES", corona.DefaultCacheTTLs.Cases),
+		Stringency: durationFromEnv("CACHE_TTL_STRINGENCY", corona.DefaultCacheTTLs.Stringency),
+		Countries:  durationFromEnv("CACHE_TTL_COUNTRIES", corona.DefaultCacheTTLs.Countries),
+	}
+}
+
+// Get where requests to webhooks may go from environment variables $EGRESS_SCHEMES and $EGRESS_BLOCKED_CIDRS,
+// both comma separated lists, and $EGRESS_MAX_REDIRECTS. $EGRESS_BLOCKED_CIDRS set to "none" blocks nothing
+func egressPolicy() *notifications.EgressPolicy {
+	policy := notifications.DefaultEgressPolicy
+	if schemes := os.Getenv("EGRESS_SCHEMES"); schemes != "" {
+		policy.Schemes = strings.Split(schemes, ",")
+	}
+	if cidrs := os.Getenv("EGRESS_BLOCKED_CIDRS"); cidrs == "none" {
+		policy.Blocked = nil
+	} else if cidrs != "" {
+		blocked, err := notifications.ParseCIDRs(strings.Split(cidrs, ","))
+		if err != nil {
+			log.Fatalf("Invalid network in $EGRESS_BLOCKED_CIDRS: %s", err.Error())
+		}
+		policy.Blocked = blocked
+	}
+	policy.MaxRedirects = intFromEnv("EGRESS_MAX_REDIRECTS", policy.MaxRedirects, 0)
+
+	return &policy
+}
+
+// Get the origins browsers may connect to the websocket from, as a comma separated list in $SOCKET_ORIGINS
+func socketOrigins() []string {
+	if origins := os.Getenv("SOCKET_ORIGINS"); origins != "" {
+		return strings.Split(origins, ",")
+	}
+	return nil
+}
+
+// Get the limits for delivering to webhooks from environment variables $DELIVERY_WORKERS, $DELIVERY_PER_HOST,
+// $DELIVERY_TIMEOUT and $DELIVERY_QUEUE_SIZE, and how to retry failed deliveries from $DELIVERY_MAX_ATTEMPTS,
+// $DELIVERY_RETRY_BASE and $DELIVERY_RETRY_MAX, and where they may go from `egress`
+func dispatcherConfig(egress *notifications.EgressPolicy) notifications.DispatcherConfig {
+	defaults := notifications.DefaultDispatcherConfig
+	return notifications.DispatcherConfig{
+		Workers:   intFromEnv("DELIVERY_WORKERS", defaults.Workers, 1),
+		PerHost:   intFromEnv("DELIVERY_PER_HOST", defaults.PerHost, 1),
+		Timeout:   durationFromEnv("DELIVERY_TIMEOUT", defaults.Timeout),
+		QueueSize: intFromEnv("DELIVERY_QUEUE_SIZE", defaults.QueueSize, 1),
+		Retry: notifications.RetryPolicy{
+			MaxAttempts: intFromEnv("DELIVERY_MAX_ATTEMPTS", defaults.Retry.MaxAttempts, 1),
+			BaseDelay:   durationFromEnv("DELIVERY_RETRY_BASE", defaults.Retry.BaseDelay),
+			MaxDelay:    durationFromEnv("DELIVERY_RETRY_MAX", defaults.Retry.MaxDelay),
+		},
+		Egress: egress,
+	}
+}
+
 // Serve the resources as defined by routes in `r`
 func serve(r *chi.Mux, wg *sync.WaitGroup) {
 	port := port()
@@ -53,7 +209,14 @@ func serve(r *chi.Mux, wg *sync.WaitGroup) {
 }
 
 // Setup all the top level routes the server serves on
-func setupRoutes(fs *firestore.Client, registerChan chan<- string) *chi.Mux {
+func setupRoutes(
+	store notifications.WebhookStore,
+	providers corona.Providers,
+	scheduler *notifications.Scheduler,
+	dispatcher *notifications.Dispatcher,
+	egress *notifications.EgressPolicy,
+	broker *notifications.Broker,
+) *chi.Mux {
 	r := chi.NewRouter()
 
 	// Use middleware
@@ -62,34 +225,70 @@ func setupRoutes(fs *firestore.Client, registerChan chan<- string) *chi.Mux {
 	r.Use(mymw.ReturnJSON)
 
 	// Define endpoints
-	r.Get(corona.DiagRootPath, corona.NewDiagHandler(fs, StartTime))
-	r.Get(corona.CountryRootPath+"/{country:[a-zA-Z]+}", corona.CountryHandler)
-	r.Get(corona.PolicyRootPath+"/{country:[a-zA-Z]+}", corona.PolicyHandler)
+	r.Get(corona.DiagRootPath, corona.NewDiagHandler(store, providers, StartTime))
+	r.Get(corona.CountryRootPath+"/{country:[a-zA-Z]+}", corona.NewCountryHandler(providers))
+	r.Get(corona.PolicyRootPath+"/{country:[a-zA-Z]+}", corona.NewPolicyHandler(providers))
+
+	// Stream changes to the data as server-sent events and over websockets, with a heartbeat every $STREAM_HEARTBEAT
+	heartbeat := durationFromEnv("STREAM_HEARTBEAT", notifications.DefaultHeartbeatInterval)
+	r.Get(notifications.StreamPath, notifications.NewStreamHandler(broker, providers, heartbeat))
+	r.Get(notifications.SocketPath, notifications.NewSocketHandler(broker, providers, socketOrigins(), heartbeat))
 
 	// Define webhook endpoints in a subroute
 	r.Route(notifications.RootPath, func(r chi.Router) {
-		r.Post("/", notifications.NewCreateHandler(fs, registerChan))
-		r.Get("/", notifications.NewReadAllHandler(fs))
-		r.Delete(notifications.IDPattern, notifications.NewDeleteHandler(fs))
-		r.Get(notifications.IDPattern, notifications.NewReadHandler(fs))
+		r.Post("/", notifications.NewCreateHandler(store, providers, scheduler, egress))
+		r.Get("/", notifications.NewReadAllHandler(store))
+		r.Delete(notifications.IDPattern, notifications.NewDeleteHandler(store, scheduler))
+		r.Get(notifications.IDPattern, notifications.NewReadHandler(store))
+		r.Patch(notifications.IDPattern, notifications.NewUpdateHandler(store, providers, scheduler, egress))
+		r.Post(notifications.IDPattern+notifications.PausePath, notifications.NewPauseHandler(store, scheduler))
+		r.Post(notifications.IDPattern+notifications.ResumePath, notifications.NewResumeHandler(store, scheduler))
+		r.Post(notifications.IDPattern+notifications.VerifyPath, notifications.NewVerifyHandler(store, scheduler, egress))
+		r.Get(notifications.IDPattern+notifications.DeliveriesPath, notifications.NewDeliveriesHandler(store))
+		r.Post(notifications.IDPattern+notifications.TestPath, notifications.NewTestHandler(store, providers, dispatcher))
+		r.Post(notifications.IDPattern+notifications.SecretPath, notifications.NewRotateSecretHandler(store, scheduler))
+
+		// Deliveries that failed even after being retried
+		r.Get(notifications.DeadLettersPath, notifications.NewDeadLettersHandler(store))
+		r.Post(notifications.DeadLettersPath+notifications.IDPattern+"/replay", notifications.NewReplayHandler(store, dispatcher))
+		r.Delete(notifications.DeadLettersPath+notifications.IDPattern, notifications.NewDeadLetterDeleteHandler(store))
 	})
 
 	return r
 }
 
 func main() {
-	// Initialize a firestore client
-	fs := fs.NewFirestoreClient()
-	defer fs.Close()
+	// Initialize the webhook store
+	store := webhookStore()
+	defer store.Close()
+
+	// Initialize the upstream data providers, and put a cache in front of them
+	providers := corona.NewCachedProviders(dataProviders(), cacheTTLs())
+
+	// Limit where requests to webhooks can go, both when they are registered and when they are delivered to
+	egress := egressPolicy()
+
+	// Deliver to webhooks concurrently
+	dispatcher := notifications.NewDispatcher(store, dispatcherConfig(egress))
+
+	// Hand the changes the scheduler and poller detect to the event stream
+	broker := notifications.NewBroker()
+
+	// Invoke webhooks as their timeouts expire
+	scheduler := notifications.NewScheduler(store, providers, dispatcher, broker)
 
-	registerChan := make(chan string)
+	// Check for changes to the data ON_CHANGE webhooks are interested in every $POLL_INTERVAL
+	pollInterval := durationFromEnv("POLL_INTERVAL", notifications.DefaultPollInterval)
+	poller := notifications.NewPoller(store, providers, dispatcher, broker, pollInterval)
 
 	wg := &sync.WaitGroup{}
-	wg.Add(2) //nolint:gomnd // How many goroutines we are about to launch
+	wg.Add(4) //nolint:gomnd // How many goroutines we are about to launch
 
-	r := setupRoutes(fs, registerChan)
+	r := setupRoutes(store, providers, scheduler, dispatcher, egress, broker)
 	go serve(r, wg)
-	go notifications.InvokeLoop(fs, registerChan, wg)
+	go dispatcher.Run(wg)
+	go scheduler.Run(wg)
+	go poller.Run(wg)
 
 	wg.Wait()
 }
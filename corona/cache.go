@@ -0,0 +1,227 @@
+package corona
+
+import (
+	"sync"
+	"time"
+)
+
+// CacheStats are the number of hits and misses a cache has seen since the server started.
+type CacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+// CacheStatter is implemented by providers that cache their data, so that the diag endpoint can report on them.
+type CacheStatter interface {
+	CacheStats() CacheStats
+}
+
+// cacheEntry is a cached value and the point in time it expires.
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// cacheCall is a fetch that is currently in flight.
+// Concurrent misses on the same key wait for the same call instead of all going upstream.
+type cacheCall struct {
+	done  chan struct{}
+	value interface{}
+	err   *ServerError
+}
+
+// Cache is a TTL cache where concurrent misses on the same key are coalesced into one fetch.
+// Errors are never cached.
+type Cache struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]cacheEntry
+	calls     map[string]*cacheCall
+	lastSweep time.Time
+	stats     CacheStats
+}
+
+// NewCache creates an empty cache where entries expire after ttl.
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:       ttl,
+		entries:   make(map[string]cacheEntry),
+		calls:     make(map[string]*cacheCall),
+		lastSweep: time.Now(),
+	}
+}
+
+// Get returns the cached value for key, or calls fetch to get it if it is missing or expired.
+// If another goroutine is already fetching the same key, Get waits for that fetch instead.
+func (c *Cache) Get(key string, fetch func() (interface{}, *ServerError)) (interface{}, *ServerError) {
+	c.mu.Lock()
+
+	if entry, ok := c.entries[key]; ok && time.Now().Before(entry.expires) {
+		c.stats.Hits++
+		c.mu.Unlock()
+		return entry.value, nil
+	}
+	c.stats.Misses++
+
+	// Someone else is already fetching this key, wait for them
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+
+	call := &cacheCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	call.value, call.err = fetch()
+
+	c.mu.Lock()
+	delete(c.calls, key)
+	if call.err == nil {
+		c.entries[key] = cacheEntry{call.value, time.Now().Add(c.ttl)}
+	}
+	c.sweep()
+	c.mu.Unlock()
+
+	close(call.done)
+
+	return call.value, call.err
+}
+
+// sweep removes expired entries, at most once per ttl, so that keys that are never requested again don't pile up.
+// Must be called with the lock held.
+func (c *Cache) sweep() {
+	now := time.Now()
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.lastSweep = now
+}
+
+// CacheStats returns the number of hits and misses so far.
+func (c *Cache) CacheStats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
+
+// CacheTTLs are how long data from each of the upstream sources is cached.
+type CacheTTLs struct {
+	Cases      time.Duration
+	Stringency time.Duration
+	Countries  time.Duration
+}
+
+// DefaultCacheTTLs are the TTLs used unless configured otherwise.
+// Case and stringency data is updated at most daily upstream, while country codes never change.
+var DefaultCacheTTLs = CacheTTLs{
+	Cases:      time.Hour,
+	Stringency: time.Hour,
+	Countries:  24 * time.Hour,
+}
+
+// NewCachedProviders wraps each of the providers in a cache with the given TTLs.
+func NewCachedProviders(providers Providers, ttls CacheTTLs) Providers {
+	return Providers{
+		Cases:      &CachedCaseProvider{providers.Cases, NewCache(ttls.Cases)},
+		Stringency: &CachedStringencyProvider{providers.Stringency, NewCache(ttls.Stringency)},
+		Countries:  &CachedCountryProvider{providers.Countries, NewCache(ttls.Countries)},
+	}
+}
+
+// CachedCaseProvider is a CaseProvider that caches the case histories returned by another CaseProvider.
+type CachedCaseProvider struct {
+	CaseProvider
+	*Cache
+}
+
+// caseHistories is the value cached by CachedCaseProvider.
+type caseHistories struct {
+	confirmed, recovered CaseHistory
+}
+
+// GetCases returns the confirmed and recovered case history of a country.
+func (p *CachedCaseProvider) GetCases(country string) (confirmed, recovered CaseHistory, err *ServerError) {
+	value, err := p.Get(country, func() (interface{}, *ServerError) {
+		confirmed, recovered, err := p.CaseProvider.GetCases(country)
+		return caseHistories{confirmed, recovered}, err
+	})
+	if err != nil {
+		return
+	}
+
+	histories := value.(caseHistories)
+	return histories.confirmed, histories.recovered, nil
+}
+
+// GetCaseCountries returns the countries there is data for.
+func (p *CachedCaseProvider) GetCaseCountries() ([]CaseCountry, *ServerError) {
+	value, err := p.Get(allCountriesKey, func() (interface{}, *ServerError) {
+		return p.CaseProvider.GetCaseCountries()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value.([]CaseCountry), nil
+}
+
+// CachedStringencyProvider is a StringencyProvider that caches the stringency returned by another StringencyProvider.
+type CachedStringencyProvider struct {
+	StringencyProvider
+	*Cache
+}
+
+// GetStringency returns the stringency of a country at the given date.
+func (p *CachedStringencyProvider) GetStringency(code, date string) (float64, *ServerError) {
+	value, err := p.Get(code+"/"+date, func() (interface{}, *ServerError) {
+		return p.StringencyProvider.GetStringency(code, date)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return value.(float64), nil
+}
+
+// CachedCountryProvider is a CountryProvider that caches the country codes returned by another CountryProvider.
+type CachedCountryProvider struct {
+	CountryProvider
+	*Cache
+}
+
+// allCountriesKey is the cache key of the list of all countries, which can never be the name of a country.
+const allCountriesKey string = "/all"
+
+// GetCountries returns all the countries known upstream.
+func (p *CachedCountryProvider) GetCountries() ([]Country, *ServerError) {
+	value, err := p.Get(allCountriesKey, func() (interface{}, *ServerError) {
+		return p.CountryProvider.GetCountries()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value.([]Country), nil
+}
+
+// GetCountryCode returns the alpha3 code of the country with the given name.
+func (p *CachedCountryProvider) GetCountryCode(name string) (string, *ServerError) {
+	value, err := p.Get(name, func() (interface{}, *ServerError) {
+		return p.CountryProvider.GetCountryCode(name)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return value.(string), nil
+}
@@ -0,0 +1,73 @@
+package corona
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCacheCoalescesMisses tests that concurrent misses on the same key only result in one fetch.
+func TestCacheCoalescesMisses(t *testing.T) {
+	cache := NewCache(time.Minute)
+
+	var fetches int32
+	release := make(chan struct{})
+	fetch := func() (interface{}, *ServerError) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		return "NOR", nil
+	}
+
+	const n = 10
+	wg := sync.WaitGroup{}
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			value, err := cache.Get("Norway", fetch)
+			assert.Nil(t, err)
+			assert.Equal(t, "NOR", value)
+		}()
+	}
+
+	// Give the goroutines a chance to pile up behind the first fetch
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches), "Concurrent misses should share one fetch")
+
+	// Now it should be a plain hit
+	_, _ = cache.Get("Norway", fetch)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+	assert.Equal(t, CacheStats{Hits: 1, Misses: n}, cache.CacheStats())
+}
+
+// TestCacheExpiry tests that entries are fetched again once they expire, and that errors are not cached.
+func TestCacheExpiry(t *testing.T) {
+	cache := NewCache(10 * time.Millisecond)
+
+	fetches := 0
+	fetch := func() (interface{}, *ServerError) {
+		fetches++
+		if fetches == 1 {
+			return nil, &ServerError{"Upstream is down", http.StatusBadGateway}
+		}
+		return 42.0, nil
+	}
+
+	_, err := cache.Get("NOR/2021-03-01", fetch)
+	assert.NotNil(t, err)
+	value, err := cache.Get("NOR/2021-03-01", fetch)
+	assert.Nil(t, err)
+	assert.Equal(t, 42.0, value)
+	assert.Equal(t, 2, fetches, "Errors should not be cached")
+
+	time.Sleep(20 * time.Millisecond)
+	_, _ = cache.Get("NOR/2021-03-01", fetch)
+	assert.Equal(t, 3, fetches, "Expired entries should be fetched again")
+}
@@ -29,7 +29,12 @@ func TimeAsString(t time.Time) string {
 
 // LatestDateInDateFloatMap returns the latest date in a map where key = date (as strings with format "yyyy-mm-dd").
 // The naming reflects the stupidity of go's type system not being able to express this function generically.
+// Returns an empty string if the map is empty.
 func LatestDateInDateFloatMap(m map[string]float64) string {
+	if len(m) == 0 {
+		return ""
+	}
+
 	// Get the keys in the map
 	keys := make([]string, 0, len(m))
 	for k := range m {
@@ -73,12 +78,17 @@ func ParseScope(qs *url.URL) (*time.Time, *time.Time, error) {
 
 // GetStatusOf returns the status code of a head request to the root path of a remote.
 func GetStatusOf(addr string) int {
+	return GetStatusWith(http.DefaultClient, addr)
+}
+
+// GetStatusWith is GetStatusOf, sending the request with client.
+func GetStatusWith(client *http.Client, addr string) int {
 	req, err := http.NewRequest(http.MethodOptions, addr, nil)
 	if err != nil {
 		log.Printf("Options request failed with: %s", err.Error())
 		return http.StatusBadRequest // Assume I did something wrong, all other errors should be "successful"
 	}
-	res, err := http.DefaultClient.Do(req)
+	res, err := client.Do(req)
 	if err != nil {
 		log.Printf("Options request failed with: %s", err.Error())
 		return http.StatusBadRequest // Assume I did something wrong, all other errors should be "successful"
@@ -3,28 +3,221 @@ package corona
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
 )
 
-// country represents a country as given by `restcountries.eu`.
-type country struct {
+// Country represents a country as given by `restcountries.eu`.
+type Country struct {
 	Name       string `json:"name"`
+	Alpha2Code string `json:"alpha2Code"`
 	Alpha3Code string `json:"alpha3Code"`
+	// Region is the continent the country is in, like Europe or Americas.
+	Region string `json:"region"`
+	// FullName is the name restcountries.eu knows the country by, when Name is the one the case provider knows it by.
+	// Only set by CaseCountries.
+	FullName string `json:"-"`
+}
+
+// CaseCountry is a country the case provider has data for.
+type CaseCountry struct {
+	// Name is what the case provider calls the country, which is not necessarily what restcountries.eu calls it.
+	Name string
+	// Alpha2Code is how the country is matched up with restcountries.eu, since they do not agree on names.
+	Alpha2Code string
+}
+
+// RestCountriesProvider is a CountryProvider that looks up countries using the restcountries.eu api.
+type RestCountriesProvider struct {
+	// RootPath of the api, normally RestCountriesRootPath.
+	RootPath string
 }
 
 // GetCountryCode gets the alpha 3 code of a given country.
-func GetCountryCode(name string) (string, *ServerError) {
-	var country [1]country
+func (p *RestCountriesProvider) GetCountryCode(name string) (string, *ServerError) {
+	var country [1]Country
 
-	res, err := http.Get(RestCountriesRootPath + "/name/" + name + "?fullText=true&fields=name;alpha3Code")
+	res, err := http.Get(p.RootPath + "/name/" + url.PathEscape(name) + "?fullText=true&fields=name;alpha3Code")
 	if err != nil {
-		return "", &ServerError{"Get country failed with: " + err.Error(), res.StatusCode}
+		return "", &ServerError{"Get country failed with: " + err.Error(), http.StatusBadGateway}
 	}
+	defer res.Body.Close()
 
 	err = json.NewDecoder(res.Body).Decode(&country)
 	if err != nil {
 		return "", &ServerError{"Failed to decode json response from restcountries.eu", http.StatusInternalServerError}
 	}
-	res.Body.Close()
 
 	return country[0].Alpha3Code, nil
 }
+
+// GetCountries gets all the countries known to restcountries.eu.
+func (p *RestCountriesProvider) GetCountries() ([]Country, *ServerError) {
+	var countries []Country
+
+	res, err := http.Get(p.RootPath + "/all?fields=name;alpha2Code;alpha3Code;region")
+	if err != nil {
+		return nil, &ServerError{"Get countries failed with: " + err.Error(), http.StatusBadGateway}
+	}
+	defer res.Body.Close()
+
+	err = json.NewDecoder(res.Body).Decode(&countries)
+	if err != nil {
+		return nil, &ServerError{"Failed to decode json response from restcountries.eu", http.StatusInternalServerError}
+	}
+
+	return countries, nil
+}
+
+// GetCountriesIn returns the countries in a region, ignoring case, that there are cases for.
+// They are named the way the case provider names them, as by CaseCountries.
+func GetCountriesIn(providers Providers, region string) ([]Country, *ServerError) {
+	countries, err := CaseCountries(providers)
+	if err != nil {
+		return nil, err
+	}
+
+	inRegion := make([]Country, 0)
+	for _, c := range countries {
+		if strings.EqualFold(c.Region, region) {
+			inRegion = append(inRegion, c)
+		}
+	}
+
+	return inRegion, nil
+}
+
+// CaseCountries returns the countries the case provider has data for, named the way it names them,
+// so that the names work with GetCases. The case provider and restcountries.eu do not agree on names,
+// like Russia and Russian Federation, so the countries are matched up by alpha2 code,
+// which fills in the alpha3 code, region and full name of each country from restcountries.eu.
+// Countries that can not be matched up are left without them.
+func CaseCountries(providers Providers) ([]Country, *ServerError) {
+	cases, err := providers.Cases.GetCaseCountries()
+	if err != nil {
+		return nil, err
+	}
+	known, err := providers.Countries.GetCountries()
+	if err != nil {
+		return nil, err
+	}
+
+	byCode := make(map[string]*Country, len(known))
+	for i := range known {
+		byCode[strings.ToUpper(known[i].Alpha2Code)] = &known[i]
+	}
+
+	countries := make([]Country, 0, len(cases))
+	for _, c := range cases {
+		country := Country{Name: c.Name, Alpha2Code: c.Alpha2Code}
+		if match, ok := byCode[strings.ToUpper(c.Alpha2Code)]; ok && c.Alpha2Code != "" {
+			country.Alpha3Code, country.Region, country.FullName = match.Alpha3Code, match.Region, match.Name
+		}
+		countries = append(countries, country)
+	}
+
+	return countries, nil
+}
+
+// maxSuggestions is how many similarly named countries FindCountry suggests at most.
+const maxSuggestions = 3
+
+// maxSuggestedName is the longest name, in letters, FindCountry suggests similar names for.
+// Comparing names takes time in proportion to their length, and no country has a name anywhere near this long.
+const maxSuggestedName = 64
+
+// FindCountry finds the country with the given name, full name or alpha3 code among countries, ignoring case.
+// If there is none, it returns the names of up to maxSuggestions countries with similar names instead, closest first,
+// unless the name is longer than maxSuggestedName.
+func FindCountry(countries []Country, name string) (*Country, []string) {
+	for i := range countries {
+		c := &countries[i]
+		if strings.EqualFold(c.Name, name) || (c.FullName != "" && strings.EqualFold(c.FullName, name)) ||
+			(c.Alpha3Code != "" && strings.EqualFold(c.Alpha3Code, name)) {
+			return c, nil
+		}
+	}
+
+	if utf8.RuneCountInString(name) > maxSuggestedName {
+		return nil, nil
+	}
+
+	type match struct {
+		name     string
+		distance int
+	}
+
+	name = strings.ToLower(name)
+	matches := make([]match, 0)
+	for _, c := range countries {
+		candidates := []string{strings.ToLower(c.Name)}
+		if c.FullName != "" {
+			candidates = append(candidates, strings.ToLower(c.FullName))
+		}
+
+		// A country is suggested at most once, by the first of its names that is close enough
+		for _, candidate := range candidates {
+			distance := editDistance(name, candidate)
+			// Allow about one typo for every three letters, or a part of the name, like "united" for "United Kingdom"
+			if distance <= len(name)/3+1 || (len(name) >= 3 && strings.Contains(candidate, name)) {
+				matches = append(matches, match{c.Name, distance})
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].distance < matches[j].distance
+	})
+	if len(matches) > maxSuggestions {
+		matches = matches[:maxSuggestions]
+	}
+
+	suggestions := make([]string, 0, len(matches))
+	for _, m := range matches {
+		suggestions = append(suggestions, m.name)
+	}
+
+	return nil, suggestions
+}
+
+// editDistance is the number of single letter insertions, deletions and substitutions needed to turn a into b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	// Only the previous row of the table is needed to compute the next one
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, minInt(cur[j-1]+1, prev[j-1]+cost))
+		}
+		prev = cur
+	}
+
+	return prev[len(rb)]
+}
+
+// minInt returns the smaller of a and b.
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Status returns the status code of restcountries.eu.
+func (p *RestCountriesProvider) Status() int {
+	return GetStatusOf(p.RootPath)
+}
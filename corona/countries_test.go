@@ -0,0 +1,111 @@
+package corona
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGetCountriesIn tests that countries are looked up by region through the restcountries api, ignoring case,
+// and that only the ones there are cases for are returned, by the names the case provider uses.
+func TestGetCountriesIn(t *testing.T) {
+	fixtures, err := LoadFixtures(fixturesPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	server, providers := NewFixtureServer(fixtures)
+	defer server.Close()
+
+	countries, serverErr := GetCountriesIn(providers, "europe")
+	assert.Nil(t, serverErr)
+	assert.Len(t, countries, len(fixtures.History))
+	assert.Contains(t, countries, Country{Name: "Norway", Alpha2Code: "NO", Alpha3Code: "NOR", Region: "Europe", FullName: "Norway"})
+	names := make([]string, 0, len(countries))
+	for _, c := range countries {
+		names = append(names, c.Name)
+	}
+	assert.Contains(t, names, "United Kingdom")
+	assert.NotContains(t, names, "Svalbard and Jan Mayen")
+
+	countries, serverErr = GetCountriesIn(providers, "Atlantis")
+	assert.Nil(t, serverErr)
+	assert.Empty(t, countries)
+}
+
+// TestCaseCountries tests that the countries with cases keep the names the case provider knows them by,
+// and are matched up with restcountries by alpha2 code even where the names differ.
+func TestCaseCountries(t *testing.T) {
+	fixtures, err := LoadFixtures(fixturesPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	server, providers := NewFixtureServer(fixtures)
+	defer server.Close()
+
+	countries, serverErr := CaseCountries(providers)
+	assert.Nil(t, serverErr)
+	assert.Len(t, countries, len(fixtures.History))
+	assert.Contains(t, countries, Country{
+		Name:       "Russia",
+		Alpha2Code: "RU",
+		Alpha3Code: "RUS",
+		Region:     "Europe",
+		FullName:   "Russian Federation",
+	})
+
+	// Either name can be used to find it, and so can the code
+	for _, name := range []string{"russia", "Russian Federation", "rus"} {
+		country, _ := FindCountry(countries, name)
+		if assert.NotNil(t, country, name) {
+			assert.Equal(t, "Russia", country.Name)
+		}
+	}
+	_, suggestions := FindCountry(countries, "United Kingdom of Great Britain")
+	assert.Equal(t, []string{"United Kingdom"}, suggestions)
+
+	// The name is the one the case provider has data for
+	confirmed, _, serverErr := providers.Cases.GetCases("Russia")
+	assert.Nil(t, serverErr)
+	assert.NotEmpty(t, confirmed.Dates)
+}
+
+// TestFindCountry tests that countries are found by name or code, and that similar names are suggested otherwise.
+func TestFindCountry(t *testing.T) {
+	countries := []Country{
+		{Name: "Norway", Alpha3Code: "NOR"},
+		{Name: "Sweden", Alpha3Code: "SWE"},
+		{Name: "United Kingdom of Great Britain and Northern Ireland", Alpha3Code: "GBR"},
+		{Name: "United States of America", Alpha3Code: "USA"},
+	}
+
+	country, suggestions := FindCountry(countries, "norway")
+	assert.Equal(t, &countries[0], country)
+	assert.Empty(t, suggestions)
+
+	country, _ = FindCountry(countries, "swe")
+	assert.Equal(t, &countries[1], country)
+
+	country, suggestions = FindCountry(countries, "Norwya")
+	assert.Nil(t, country)
+	assert.Equal(t, []string{"Norway"}, suggestions)
+
+	_, suggestions = FindCountry(countries, "united")
+	assert.ElementsMatch(t, []string{countries[2].Name, countries[3].Name}, suggestions)
+
+	_, suggestions = FindCountry(countries, "Atlantis")
+	assert.Empty(t, suggestions)
+
+	// Names far longer than any country's are not worth comparing letter by letter
+	country, suggestions = FindCountry(countries, strings.Repeat("united", maxSuggestedName))
+	assert.Nil(t, country)
+	assert.Empty(t, suggestions)
+}
+
+// TestEditDistance tests the edit distance of a few pairs of words.
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("norway", "norway"))
+	assert.Equal(t, 2, editDistance("norwya", "norway"))
+	assert.Equal(t, 3, editDistance("kitten", "sitting"))
+	assert.Equal(t, 6, editDistance("", "sweden"))
+}
@@ -5,6 +5,8 @@ import (
 	"log"
 	"math"
 	"net/http"
+	"net/url"
+	"sort"
 	"time"
 
 	"github.com/go-chi/chi"
@@ -20,17 +22,18 @@ type CountryResponse struct {
 	PopulationPercentage float64 `json:"population_percentage"`
 }
 
-// caseHistory for one country as reported by mmediagroup.
+// CaseHistory for one country as reported by mmediagroup.
 // Some fields are omitted because we don't need them.
-type caseHistory struct {
-	Country    string             `json:"country"`
-	Continent  string             `json:"continent"`
-	Population float64            `json:"population"`
-	Dates      map[string]float64 `json:"dates"`
+type CaseHistory struct {
+	Country      string             `json:"country"`
+	Continent    string             `json:"continent"`
+	Abbreviation string             `json:"abbreviation"` // The alpha2 code of the country
+	Population   float64            `json:"population"`
+	Dates        map[string]float64 `json:"dates"`
 }
 
 // Count all the cases within a scope in time.
-func (cases *caseHistory) countInScope(upper, lower time.Time) float64 {
+func (cases *CaseHistory) countInScope(upper, lower time.Time) float64 {
 	start := TimeAsString(upper)
 	end := TimeAsString(lower)
 
@@ -38,114 +41,161 @@ func (cases *caseHistory) countInScope(upper, lower time.Time) float64 {
 }
 
 // latestCount gets the latest count of cases.
-func (cases *caseHistory) latestCount() float64 {
+func (cases *CaseHistory) latestCount() float64 {
 	key := LatestDateInDateFloatMap(cases.Dates)
 	return cases.Dates[key]
 }
 
-func getCases(country string) (confirmed, recovered caseHistory, err *ServerError) {
-	root := MMediaGroupAPIRootPath + "/history?country=" + country
+// populationPercentage returns the share of the population that cases make up.
+func populationPercentage(cases float64, history *CaseHistory) float64 {
+	if history.Population <= 0 {
+		return 0 // Unknown population, avoid dividing by zero
+	}
 
-	cases := make(map[string]caseHistory)
+	//nolint:gomnd // We want 2 digits of precision, hence 100
+	return math.Round(cases/history.Population*100) / 100
+}
 
-	// Get confirmed cases
-	res, geterr := http.Get(root + "&status=Confirmed")
-	if geterr != nil {
-		err = &ServerError{"Failed to get cases for country", res.StatusCode}
-		return
-	}
+// MMediaGroupProvider is a CaseProvider that gets case histories from the mmediagroup covid api.
+type MMediaGroupProvider struct {
+	// RootPath of the api, normally MMediaGroupAPIRootPath.
+	RootPath string
+}
 
-	decerr := json.NewDecoder(res.Body).Decode(&cases)
-	if decerr != nil {
-		err = &ServerError{"Failed to decode response from remote", http.StatusInternalServerError}
-		return
-	}
-	res.Body.Close()
+// getHistory gets the history of cases with a given status (Confirmed or Recovered) for a country.
+func (p *MMediaGroupProvider) getHistory(country, status string) (CaseHistory, *ServerError) {
+	cases := make(map[string]CaseHistory)
 
-	confirmed = cases["All"]
+	// Names like United Kingdom have to be escaped
+	res, err := http.Get(p.RootPath + "/history?country=" + url.QueryEscape(country) + "&status=" + url.QueryEscape(status))
+	if err != nil {
+		return CaseHistory{}, &ServerError{"Failed to get cases for country", http.StatusBadGateway}
+	}
+	defer res.Body.Close()
 
-	// Get recovered cases
-	res, geterr = http.Get(root + "&status=Recovered")
-	if geterr != nil {
-		err = &ServerError{"Failed to get cases for country", res.StatusCode}
-		return
+	err = json.NewDecoder(res.Body).Decode(&cases)
+	if err != nil {
+		return CaseHistory{}, &ServerError{"Failed to decode response from remote", http.StatusInternalServerError}
 	}
 
-	decerr = json.NewDecoder(res.Body).Decode(&cases)
-	if decerr != nil {
-		err = &ServerError{"Failed to decode response from remote", http.StatusInternalServerError}
+	return cases["All"], nil
+}
+
+// GetCases returns the confirmed and recovered case history of a country.
+func (p *MMediaGroupProvider) GetCases(country string) (confirmed, recovered CaseHistory, err *ServerError) {
+	confirmed, err = p.getHistory(country, "Confirmed")
+	if err != nil {
 		return
 	}
-	res.Body.Close()
 
-	recovered = cases["All"]
+	recovered, err = p.getHistory(country, "Recovered")
 
 	return confirmed, recovered, err
 }
 
-func GetLatestCases(country string) (CountryResponse, *ServerError) {
-	var response CountryResponse
+// GetCaseCountries returns the countries mmediagroup has cases for, by the names it knows them by.
+func (p *MMediaGroupProvider) GetCaseCountries() ([]CaseCountry, *ServerError) {
+	// Only the parts of the response that identify the country are decoded
+	var cases map[string]map[string]struct {
+		Abbreviation string `json:"abbreviation"`
+	}
 
-	confirmed, recovered, err := getCases(country)
+	res, err := http.Get(p.RootPath + "/cases")
 	if err != nil {
-		return response, err
+		return nil, &ServerError{"Failed to get countries with cases", http.StatusBadGateway}
 	}
+	defer res.Body.Close()
 
-	response.Country = confirmed.Country
-	response.Continent = confirmed.Continent
-	response.Scope = "total"
-	response.Confirmed = confirmed.latestCount()
-	response.Recovered = recovered.latestCount()
+	err = json.NewDecoder(res.Body).Decode(&cases)
+	if err != nil {
+		return nil, &ServerError{"Failed to decode response from remote", http.StatusInternalServerError}
+	}
 
-	return response, nil
+	countries := make([]CaseCountry, 0, len(cases))
+	for name, regions := range cases {
+		all, ok := regions["All"]
+		if !ok {
+			continue
+		}
+		countries = append(countries, CaseCountry{Name: name, Alpha2Code: all.Abbreviation})
+	}
+	sort.Slice(countries, func(i, j int) bool {
+		return countries[i].Name < countries[j].Name
+	})
+
+	return countries, nil
 }
 
-// CountryHandler is the handler for the country endpoint.
-func CountryHandler(rw http.ResponseWriter, r *http.Request) {
+// Status returns the status code of the mmediagroup api.
+func (p *MMediaGroupProvider) Status() int {
+	return GetStatusOf(p.RootPath + "/cases")
+}
+
+// GetLatestCases returns the latest total number of cases for a given country.
+func GetLatestCases(providers Providers, country string) (CountryResponse, *ServerError) {
 	var response CountryResponse
-	var scoped bool
 
-	country := chi.URLParam(r, "country")
-	// Parse the scope query into two dates
-	upper, lower, err := ParseScope(r.URL)
+	confirmed, recovered, err := providers.Cases.GetCases(country)
 	if err != nil {
-		log.Printf("Invalid request received: %s", err.Error())
-		http.Error(rw, "Bad request: check the scope query.", http.StatusBadRequest)
-		return
-	}
-	if upper == nil {
-		scoped = false
-	} else {
-		scoped = true
-	}
-
-	confirmed, recovered, serverErr := getCases(country)
-	if serverErr != nil {
-		http.Error(rw, serverErr.Error(), serverErr.StatusCode)
+		return response, err
 	}
 
 	response.Country = confirmed.Country
 	response.Continent = confirmed.Continent
+	response.Scope = "total"
+	response.Confirmed = confirmed.latestCount()
+	response.Recovered = recovered.latestCount()
+	response.PopulationPercentage = populationPercentage(response.Confirmed, &confirmed)
 
-	if scoped {
-		response.Scope = TimeAsString(*upper) + "-" + TimeAsString(*lower)
-		response.Confirmed = confirmed.countInScope(*upper, *lower)
-		response.Recovered = recovered.countInScope(*upper, *lower)
-	} else {
-		response.Scope = "total"
-		response.Confirmed = confirmed.latestCount()
-		response.Recovered = recovered.latestCount()
-	}
-
-	//nolint:gomnd // We want 2 digits of precision, hence 100
-	response.PopulationPercentage = math.Round(
-		response.Confirmed/confirmed.Population*100,
-	) / 100
+	return response, nil
+}
 
-	err = json.NewEncoder(rw).Encode(response)
-	if err != nil {
-		log.Printf("Something went wrong: %s", err.Error())
-		http.Error(rw, "Something went wrong", http.StatusInternalServerError)
-		return
+// NewCountryHandler creates the handler for the country endpoint.
+func NewCountryHandler(providers Providers) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var response CountryResponse
+		var scoped bool
+
+		country := chi.URLParam(r, "country")
+		// Parse the scope query into two dates
+		upper, lower, err := ParseScope(r.URL)
+		if err != nil {
+			log.Printf("Invalid request received: %s", err.Error())
+			http.Error(rw, "Bad request: check the scope query.", http.StatusBadRequest)
+			return
+		}
+		if upper == nil {
+			scoped = false
+		} else {
+			scoped = true
+		}
+
+		confirmed, recovered, serverErr := providers.Cases.GetCases(country)
+		if serverErr != nil {
+			http.Error(rw, serverErr.Error(), serverErr.StatusCode)
+			return
+		}
+
+		response.Country = confirmed.Country
+		response.Continent = confirmed.Continent
+
+		if scoped {
+			response.Scope = TimeAsString(*upper) + "-" + TimeAsString(*lower)
+			response.Confirmed = confirmed.countInScope(*upper, *lower)
+			response.Recovered = recovered.countInScope(*upper, *lower)
+		} else {
+			response.Scope = "total"
+			response.Confirmed = confirmed.latestCount()
+			response.Recovered = recovered.latestCount()
+		}
+
+		response.PopulationPercentage = populationPercentage(response.Confirmed, &confirmed)
+
+		err = json.NewEncoder(rw).Encode(response)
+		if err != nil {
+			log.Printf("Something went wrong: %s", err.Error())
+			http.Error(rw, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
 	}
 }
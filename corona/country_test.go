@@ -0,0 +1,108 @@
+package corona
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCases is a CaseProvider that serves the same case history for every country.
+type fakeCases struct {
+	confirmed, recovered CaseHistory
+}
+
+func (f *fakeCases) GetCases(country string) (confirmed, recovered CaseHistory, err *ServerError) {
+	return f.confirmed, f.recovered, nil
+}
+
+func (f *fakeCases) GetCaseCountries() ([]CaseCountry, *ServerError) {
+	return []CaseCountry{{Name: f.confirmed.Country, Alpha2Code: f.confirmed.Abbreviation}}, nil
+}
+
+func (f *fakeCases) Status() int {
+	return http.StatusOK
+}
+
+// TestCountryHandler tests the country endpoint against a fake case provider, both with and without a scope.
+func TestCountryHandler(t *testing.T) {
+	cases := &fakeCases{
+		confirmed: CaseHistory{
+			Country:    "Norway",
+			Continent:  "Europe",
+			Population: 1000,
+			Dates:      map[string]float64{"2021-03-01": 100, "2021-03-02": 150, "2021-03-03": 200},
+		},
+		recovered: CaseHistory{
+			Dates: map[string]float64{"2021-03-01": 10, "2021-03-02": 20, "2021-03-03": 30},
+		},
+	}
+	handler := NewCountryHandler(Providers{Cases: cases})
+
+	tests := []struct {
+		query    string
+		expected CountryResponse
+	}{
+		{"", CountryResponse{"Norway", "Europe", "total", 200, 30, 0.2}},
+		{"?scope=2021-03-01-2021-03-03", CountryResponse{"Norway", "Europe", "2021-03-01-2021-03-03", 100, 20, 0.1}},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, CountryRootPath+"/Norway"+test.query, nil)
+		routeCtx := chi.NewRouteContext()
+		routeCtx.URLParams.Add("country", "Norway")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, routeCtx))
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		var body CountryResponse
+		err := json.NewDecoder(rr.Body).Decode(&body)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.Equal(t, test.expected, body)
+	}
+}
+
+// TestGetLatestCases tests that the latest cases include the population percentage, like the country endpoint does.
+func TestGetLatestCases(t *testing.T) {
+	cases := &fakeCases{
+		confirmed: CaseHistory{Country: "Norway", Population: 1000, Dates: map[string]float64{"2021-03-01": 100, "2021-03-02": 150}},
+		recovered: CaseHistory{Dates: map[string]float64{"2021-03-01": 10, "2021-03-02": 20}},
+	}
+
+	response, err := GetLatestCases(Providers{Cases: cases}, "Norway")
+	assert.Nil(t, err)
+	assert.Equal(t, CountryResponse{"Norway", "", "total", 150, 20, 0.15}, response)
+
+	cases.confirmed.Population = 0
+	response, _ = GetLatestCases(Providers{Cases: cases}, "Norway")
+	assert.Zero(t, response.PopulationPercentage, "An unknown population should not divide by zero")
+}
+
+// TestNamesWithSpaces tests that countries whose names have spaces in them can be looked up through the http providers,
+// which have to escape the names.
+func TestNamesWithSpaces(t *testing.T) {
+	fixtures, err := LoadFixtures(fixturesPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	server, providers := NewFixtureServer(fixtures)
+	defer server.Close()
+
+	confirmed, _, serverErr := providers.Cases.GetCases("United Kingdom")
+	if assert.Nil(t, serverErr) {
+		assert.Equal(t, "United Kingdom", confirmed.Country)
+		assert.NotEmpty(t, confirmed.Dates)
+	}
+
+	code, serverErr := providers.Countries.GetCountryCode("United Kingdom of Great Britain and Northern Ireland")
+	assert.Nil(t, serverErr)
+	assert.Equal(t, "GBR", code)
+}
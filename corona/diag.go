@@ -1,7 +1,6 @@
 package corona
 
 import (
-	"cloud.google.com/go/firestore"
 	"context"
 	"encoding/json"
 	"log"
@@ -17,38 +16,52 @@ type diag struct {
 	Registered       int    `json:"registered"`
 	Version          string `json:"version"`
 	Uptime           int    `json:"uptime"`
+	// Cache reports the hits and misses of each cached upstream source, and is omitted if nothing is cached.
+	Cache map[string]CacheStats `json:"cache,omitempty"`
 }
 
-// countWebhooks returns the number of registered webhooks.
-func countWebhooks(fs *firestore.Client) (int, error) {
-	// Get refs to all the documents in the collection
-	// FIXME: Use the WebhookCollection constant instead, but it's a cyclic dependency and I'm at T-1, so f it
-	docrefs, err := fs.Collection("webhooks").DocumentRefs(context.Background()).GetAll()
-	if err != nil {
-		return 0, err
+// WebhookCounter is anything that can count the number of registered webhooks.
+type WebhookCounter interface {
+	Count(ctx context.Context) (int, error)
+}
+
+// cacheStats collects the stats of any of the providers that are cached.
+func cacheStats(providers Providers) map[string]CacheStats {
+	sources := map[string]interface{}{
+		"cases":      providers.Cases,
+		"stringency": providers.Stringency,
+		"countries":  providers.Countries,
+	}
+
+	stats := make(map[string]CacheStats)
+	for name, provider := range sources {
+		if cached, ok := provider.(CacheStatter); ok {
+			stats[name] = cached.CacheStats()
+		}
 	}
 
-	return len(docrefs), nil
+	return stats
 }
 
 // NewDiagHandler returns a handler function for the diagnostic endpoint.
-func NewDiagHandler(fs *firestore.Client, startTime time.Time) http.HandlerFunc {
+func NewDiagHandler(webhooks WebhookCounter, providers Providers, startTime time.Time) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		uptime := int(time.Since(startTime).Seconds())
 
-		registered, err := countWebhooks(fs)
+		registered, err := webhooks.Count(r.Context())
 		if err != nil {
 			log.Println("Error while counting webhooks:", err.Error())
 			registered = -1 // Just return a nonsense count, which more useful in this particular case
 		}
 
 		response := diag{
-			GetStatusOf(MMediaGroupAPIRootPath + "/cases"),
-			GetStatusOf(CovidTrackerAPIRootPath[0 : len(CovidTrackerAPIRootPath)-3]),
-			GetStatusOf(RestCountriesRootPath),
+			providers.Cases.Status(),
+			providers.Stringency.Status(),
+			providers.Countries.Status(),
 			registered,
 			Version,
 			uptime,
+			cacheStats(providers),
 		}
 
 		_ = json.NewEncoder(rw).Encode(response)
@@ -1,7 +1,7 @@
 package corona
 
 import (
-	fs "assignment-2/firestore"
+	"context"
 	"encoding/json"
 	"github.com/stretchr/testify/assert"
 	"net/http"
@@ -10,12 +10,26 @@ import (
 	"time"
 )
 
+// staticCounter is a WebhookCounter that always reports the same number of webhooks.
+type staticCounter int
+
+func (c staticCounter) Count(ctx context.Context) (int, error) {
+	return int(c), nil
+}
+
+// fixturesPath is the path of the fixture directory relative to this package.
+const fixturesPath string = "../fixtures"
+
 // TestDiagEndpoint tests that diag responds with the expected status code, body, and content-type.
-// It also test that all the third party apis return with the expected status code.
+// It also test that all the third party apis return with the expected status code,
+// using the fixture server in place of the real apis.
 func TestDiagEndpoint(t *testing.T) {
-	// Initialize a firestore client
-	fs := fs.NewFirestoreClient()
-	defer fs.Close()
+	fixtures, err := LoadFixtures(fixturesPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	server, providers := NewFixtureServer(fixtures)
+	defer server.Close()
 
 	req, err := http.NewRequest(http.MethodGet, "/exchange/v1/diag", nil)
 	if err != nil {
@@ -23,7 +37,7 @@ func TestDiagEndpoint(t *testing.T) {
 	}
 
 	rr := httptest.NewRecorder()
-	handler := NewDiagHandler(fs, time.Now())
+	handler := NewDiagHandler(staticCounter(3), providers, time.Now())
 	handler.ServeHTTP(rr, req)
 
 	assert.Equal(t, rr.Code, http.StatusOK, "Status code should be 200 status ok")
@@ -34,7 +48,8 @@ func TestDiagEndpoint(t *testing.T) {
 		t.Fatal(err.Error())
 	}
 
-	// NOTE: CovidTrackerAPI returns "204 no content" for options requests for some reason.
+	assert.Equal(t, 3, body.Registered, "Registered should be the number of webhooks in the store")
+
 	assert.True(t, StatusIs2XX(body.CovidTrackerAPI), "Status code is not 2XX")
 	assert.True(t, StatusIs2XX(body.MMediaGroupAPI), "Status code is not 2XX")
 	assert.True(t, StatusIs2XX(body.RestCountriesAPI), "Status code is not 2XX")
@@ -0,0 +1,129 @@
+package corona
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Files that make up a fixture directory, each mimicking the shape of the upstream api it stands in for.
+const (
+	// HistoryFixture maps country name -> status (Confirmed or Recovered) -> the mmediagroup /history response.
+	HistoryFixture string = "history.json"
+	// StringencyFixture maps alpha3 code -> date (yyyy-mm-dd) -> stringency.
+	StringencyFixture string = "stringency.json"
+	// CountriesFixture is a list of countries as returned by restcountries.eu.
+	CountriesFixture string = "countries.json"
+)
+
+// Fixtures is canned upstream data loaded from JSON files.
+// It implements CaseProvider, StringencyProvider and CountryProvider, so it can stand in for all the upstream apis.
+type Fixtures struct {
+	History    map[string]map[string]map[string]CaseHistory
+	Stringency map[string]map[string]float64
+	Countries  []Country
+}
+
+// readJSON decodes the JSON file at path into v.
+func readJSON(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// LoadFixtures reads the fixture files from the directory dir.
+func LoadFixtures(dir string) (*Fixtures, error) {
+	var f Fixtures
+
+	err := readJSON(filepath.Join(dir, HistoryFixture), &f.History)
+	if err != nil {
+		return nil, err
+	}
+
+	err = readJSON(filepath.Join(dir, StringencyFixture), &f.Stringency)
+	if err != nil {
+		return nil, err
+	}
+
+	err = readJSON(filepath.Join(dir, CountriesFixture), &f.Countries)
+	if err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+// Providers returns the fixtures as the providers for all the upstream data.
+func (f *Fixtures) Providers() Providers {
+	return Providers{f, f, f}
+}
+
+// GetCases returns the confirmed and recovered case history of a country.
+// Like mmediagroup, unknown countries result in empty histories rather than an error.
+func (f *Fixtures) GetCases(country string) (confirmed, recovered CaseHistory, err *ServerError) {
+	statuses := f.History[country]
+	return statuses["Confirmed"]["All"], statuses["Recovered"]["All"], nil
+}
+
+// GetCaseCountries returns the countries there are case histories for, sorted by name.
+func (f *Fixtures) GetCaseCountries() ([]CaseCountry, *ServerError) {
+	countries := make([]CaseCountry, 0, len(f.History))
+	for name, statuses := range f.History {
+		countries = append(countries, CaseCountry{Name: name, Alpha2Code: statuses["Confirmed"]["All"].Abbreviation})
+	}
+	sort.Slice(countries, func(i, j int) bool {
+		return countries[i].Name < countries[j].Name
+	})
+
+	return countries, nil
+}
+
+// GetStringency returns the stringency of a country at the given date.
+// If there is no data for that exact date, the latest data before it is used,
+// so that the fixtures stay useful as time goes on.
+func (f *Fixtures) GetStringency(code, date string) (float64, *ServerError) {
+	dates := f.Stringency[code]
+	if stringency, ok := dates[date]; ok {
+		return stringency, nil
+	}
+
+	// Dates are yyyy-mm-dd, so they compare correctly as strings
+	latest := ""
+	for d := range dates {
+		if d <= date && d > latest {
+			latest = d
+		}
+	}
+	if latest == "" {
+		return -1, nil
+	}
+
+	return dates[latest], nil
+}
+
+// GetCountryCode returns the alpha3 code of the country with the given name, ignoring case.
+func (f *Fixtures) GetCountryCode(name string) (string, *ServerError) {
+	for _, c := range f.Countries {
+		if strings.EqualFold(c.Name, name) {
+			return c.Alpha3Code, nil
+		}
+	}
+
+	return "", &ServerError{"No country named " + name, http.StatusNotFound}
+}
+
+// GetCountries returns all the countries in the fixtures.
+func (f *Fixtures) GetCountries() ([]Country, *ServerError) {
+	return f.Countries, nil
+}
+
+// Status of the fixtures, which are always available.
+func (f *Fixtures) Status() int {
+	return http.StatusOK
+}
@@ -0,0 +1,107 @@
+package corona
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/go-chi/chi"
+)
+
+// Path prefixes of the fake apis served by the fixture server.
+const (
+	FixtureMMediaGroupPath   string = "/mmediagroup/v1"
+	FixtureCovidTrackerPath  string = "/covidtracker/api/v2"
+	FixtureRestCountriesPath string = "/restcountries/rest/v2"
+)
+
+// Handler returns a http handler that serves the fixtures in the same shape as the upstream apis,
+// so that the http providers can be tested without access to the internet.
+func (f *Fixtures) Handler() http.Handler {
+	r := chi.NewRouter()
+
+	// Answer the OPTIONS requests sent by GetStatusOf on any path, like the real apis do
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				rw.WriteHeader(http.StatusOK)
+				return
+			}
+			next.ServeHTTP(rw, r)
+		})
+	})
+
+	// mmediagroup: /history?country={name}&status={status}
+	r.Get(FixtureMMediaGroupPath+"/history", func(rw http.ResponseWriter, r *http.Request) {
+		country := r.URL.Query().Get("country")
+		status := r.URL.Query().Get("status")
+
+		response := f.History[country][status]
+		if response == nil {
+			response = make(map[string]CaseHistory)
+		}
+		_ = json.NewEncoder(rw).Encode(response)
+	})
+
+	// mmediagroup: /cases, of which only the country and abbreviation are filled out
+	r.Get(FixtureMMediaGroupPath+"/cases", func(rw http.ResponseWriter, r *http.Request) {
+		response := make(map[string]map[string]CaseHistory)
+		for name, statuses := range f.History {
+			all := statuses["Confirmed"]["All"]
+			response[name] = map[string]CaseHistory{"All": {Country: all.Country, Abbreviation: all.Abbreviation}}
+		}
+		_ = json.NewEncoder(rw).Encode(response)
+	})
+
+	// covidtracker: /stringency/actions/{code}/{date}
+	r.Get(FixtureCovidTrackerPath+"/stringency/actions/{code}/{date}", func(rw http.ResponseWriter, r *http.Request) {
+		code := chi.URLParam(r, "code")
+		date := chi.URLParam(r, "date")
+
+		var response struct {
+			Data map[string]interface{} `json:"stringencyData"`
+		}
+		stringency, _ := f.GetStringency(code, date)
+		if stringency < 0 {
+			response.Data = map[string]interface{}{"msg": "Data unavailable"}
+		} else {
+			response.Data = map[string]interface{}{"date_value": date, "country_code": code, "stringency": stringency}
+		}
+		_ = json.NewEncoder(rw).Encode(response)
+	})
+
+	// restcountries: /name/{name}
+	r.Get(FixtureRestCountriesPath+"/name/{name}", func(rw http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+
+		code, err := f.GetCountryCode(name)
+		if err != nil {
+			rw.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(rw).Encode(map[string]interface{}{"status": http.StatusNotFound, "message": "Not Found"})
+			return
+		}
+		_ = json.NewEncoder(rw).Encode([]Country{{Name: name, Alpha3Code: code}})
+	})
+
+	// restcountries: /all
+	r.Get(FixtureRestCountriesPath+"/all", func(rw http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(rw).Encode(f.Countries)
+	})
+
+	return r
+}
+
+// NewFixtureServer starts a local http server serving the fixtures,
+// and returns it along with http providers that fetch their data from it.
+// The caller is responsible for closing the server.
+func NewFixtureServer(f *Fixtures) (*httptest.Server, Providers) {
+	server := httptest.NewServer(f.Handler())
+
+	providers := Providers{
+		Cases:      &MMediaGroupProvider{server.URL + FixtureMMediaGroupPath},
+		Stringency: &CovidTrackerProvider{server.URL + FixtureCovidTrackerPath},
+		Countries:  &RestCountriesProvider{server.URL + FixtureRestCountriesPath},
+	}
+
+	return server, providers
+}
@@ -33,37 +33,55 @@ type covidTrackerAPIResponse struct {
 	Data covidTrackerAPIStringencyData `json:"stringencyData"`
 }
 
-// getStringency for a given country's alpha3 code at a given date.
-func getStringency(code, date string) (response covidTrackerAPIResponse, err *ServerError) {
-	res, geterr := http.Get(CovidTrackerAPIRootPath + "/stringency/actions/" + code + "/" + date)
-	if geterr != nil {
-		err = &ServerError{"Failed to get cases for country", res.StatusCode}
-		return
+// CovidTrackerProvider is a StringencyProvider that gets stringency data from the oxford covid tracker api.
+type CovidTrackerProvider struct {
+	// RootPath of the api, normally CovidTrackerAPIRootPath.
+	RootPath string
+}
+
+// GetStringency for a given country's alpha3 code at a given date.
+func (p *CovidTrackerProvider) GetStringency(code, date string) (float64, *ServerError) {
+	var response covidTrackerAPIResponse
+
+	res, err := http.Get(p.RootPath + "/stringency/actions/" + code + "/" + date)
+	if err != nil {
+		return 0, &ServerError{"Failed to get cases for country", http.StatusBadGateway}
 	}
+	defer res.Body.Close()
 
 	// Set default value if not available
 	response.Data.Stringency = -1
 
-	decerr := json.NewDecoder(res.Body).Decode(&response)
-	if decerr != nil {
-		err = &ServerError{"Failed to decode response from remote", http.StatusInternalServerError}
-		return
+	err = json.NewDecoder(res.Body).Decode(&response)
+	if err != nil {
+		return 0, &ServerError{"Failed to decode response from remote", http.StatusInternalServerError}
 	}
-	res.Body.Close()
 
-	return
+	return response.Data.Stringency, nil
+}
+
+// Status returns the status code of the covid tracker api.
+func (p *CovidTrackerProvider) Status() int {
+	// The api responds to requests on it's root path without the version suffix
+	return GetStatusOf(p.RootPath[0 : len(p.RootPath)-3])
 }
 
 // GetLatestStringency returns the latest available stringency information for a given country.
-func GetLatestStringency(country string) (response PolicyResponse, err *ServerError) {
+func GetLatestStringency(providers Providers, country string) (response PolicyResponse, err *ServerError) {
 	// Get the alpha3code for the country
-	code, err := GetCountryCode(country)
+	code, err := providers.Countries.GetCountryCode(country)
 	if err != nil {
 		return
 	}
 
+	return GetLatestStringencyOf(providers, country, code)
+}
+
+// GetLatestStringencyOf returns the latest available stringency information for a country,
+// given by its name and alpha3 code, for when the code is already known.
+func GetLatestStringencyOf(providers Providers, country, code string) (response PolicyResponse, err *ServerError) {
 	// Get the latest stringency info
-	res, err := getStringency(code, TimeAsString(time.Now().AddDate(0, 0, -2)))
+	stringency, err := providers.Stringency.GetStringency(code, TimeAsString(time.Now().AddDate(0, 0, -2)))
 	if err != nil {
 		return
 	}
@@ -71,71 +89,74 @@ func GetLatestStringency(country string) (response PolicyResponse, err *ServerEr
 	// Fill out response data
 	response.Country = country
 	response.Scope = "total"
-	response.Stringency = res.Data.Stringency
+	response.Stringency = stringency
 	response.Trend = 0
 
 	return response, nil
 }
 
-func PolicyHandler(rw http.ResponseWriter, r *http.Request) {
-	var response PolicyResponse
-	var scoped bool
-
-	country := chi.URLParam(r, "country")
-	upper, lower, err := ParseScope(r.URL)
-	if err != nil {
-		log.Printf("Invalid request received: %s", err.Error())
-		http.Error(rw, "Bad request: check the scope query.", http.StatusBadRequest)
-		return
-	}
-	if upper == nil {
-		scoped = false
-	} else {
-		scoped = true
-	}
-
-	// If scope query was passed, fetch data for all the dates in range
-	if scoped {
-		alpha3code, serverErr := GetCountryCode(country)
-		if serverErr != nil {
-			http.Error(rw, serverErr.Error(), serverErr.StatusCode)
+// NewPolicyHandler creates the handler for the policy endpoint.
+func NewPolicyHandler(providers Providers) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var response PolicyResponse
+		var scoped bool
+
+		country := chi.URLParam(r, "country")
+		upper, lower, err := ParseScope(r.URL)
+		if err != nil {
+			log.Printf("Invalid request received: %s", err.Error())
+			http.Error(rw, "Bad request: check the scope query.", http.StatusBadRequest)
 			return
 		}
-
-		// Fetch stringency info for the two dates
-		upperRes, serverErr := getStringency(alpha3code, TimeAsString(*upper))
-		if serverErr != nil {
-			http.Error(rw, serverErr.Error(), serverErr.StatusCode)
-			return
-		}
-		lowerRes, serverErr := getStringency(alpha3code, TimeAsString(*lower))
-		if serverErr != nil {
-			http.Error(rw, serverErr.Error(), serverErr.StatusCode)
-			return
+		if upper == nil {
+			scoped = false
+		} else {
+			scoped = true
 		}
 
-		// Fill out response data
-		response.Country = country
-		response.Scope = TimeAsString(*upper) + "-" + TimeAsString(*lower)
-
-		// Take current stringency from latest of the two
-		response.Stringency = lowerRes.Data.Stringency
+		// If scope query was passed, fetch data for all the dates in range
+		if scoped {
+			alpha3code, serverErr := providers.Countries.GetCountryCode(country)
+			if serverErr != nil {
+				http.Error(rw, serverErr.Error(), serverErr.StatusCode)
+				return
+			}
+
+			// Fetch stringency info for the two dates
+			upperStringency, serverErr := providers.Stringency.GetStringency(alpha3code, TimeAsString(*upper))
+			if serverErr != nil {
+				http.Error(rw, serverErr.Error(), serverErr.StatusCode)
+				return
+			}
+			lowerStringency, serverErr := providers.Stringency.GetStringency(alpha3code, TimeAsString(*lower))
+			if serverErr != nil {
+				http.Error(rw, serverErr.Error(), serverErr.StatusCode)
+				return
+			}
+
+			// Fill out response data
+			response.Country = country
+			response.Scope = TimeAsString(*upper) + "-" + TimeAsString(*lower)
+
+			// Take current stringency from latest of the two
+			response.Stringency = lowerStringency
+
+			// Trend is difference in stringency from first date to last data in scope
+			response.Trend = lowerStringency - upperStringency
+		} else { // Fetch data for latest available date
+			var serverErr *ServerError // Avoid shadowing
+			response, serverErr = GetLatestStringency(providers, country)
+			if serverErr != nil {
+				http.Error(rw, serverErr.Error(), serverErr.StatusCode)
+				return
+			}
+		}
 
-		// Trend is difference in stringency from first date to last data in scope
-		response.Trend = lowerRes.Data.Stringency - upperRes.Data.Stringency
-	} else { // Fetch data for latest available date
-		var serverErr *ServerError // Avoid shadowing
-		response, serverErr = GetLatestStringency(country)
-		if serverErr != nil {
-			http.Error(rw, serverErr.Error(), serverErr.StatusCode)
+		err = json.NewEncoder(rw).Encode(response)
+		if err != nil {
+			log.Printf("Something went wrong: %s", err.Error())
+			http.Error(rw, "Something went wrong", http.StatusInternalServerError)
 			return
 		}
 	}
-
-	err = json.NewEncoder(rw).Encode(response)
-	if err != nil {
-		log.Printf("Something went wrong: %s", err.Error())
-		http.Error(rw, "Something went wrong", http.StatusInternalServerError)
-		return
-	}
 }
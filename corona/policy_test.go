@@ -0,0 +1,57 @@
+package corona
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPolicyHandler tests the policy endpoint against the fixture server, both with and without a scope.
+func TestPolicyHandler(t *testing.T) {
+	fixtures, err := LoadFixtures(fixturesPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	server, providers := NewFixtureServer(fixtures)
+	defer server.Close()
+
+	handler := NewPolicyHandler(providers)
+
+	tests := []struct {
+		country  string
+		query    string
+		status   int
+		expected PolicyResponse
+	}{
+		{"Norway", "", http.StatusOK, PolicyResponse{"Norway", "total", 62.04, 0}},
+		{"Norway", "?scope=2021-03-01-2021-03-07", http.StatusOK, PolicyResponse{"Norway", "2021-03-01-2021-03-07", 62.04, 5.56}},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, PolicyRootPath+"/"+test.country+test.query, nil)
+		routeCtx := chi.NewRouteContext()
+		routeCtx.URLParams.Add("country", test.country)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, routeCtx))
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, test.status, rr.Code)
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		var body PolicyResponse
+		err := json.NewDecoder(rr.Body).Decode(&body)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.Equal(t, test.expected.Scope, body.Scope)
+		assert.InDelta(t, test.expected.Stringency, body.Stringency, 0.001)
+		assert.InDelta(t, test.expected.Trend, body.Trend, 0.001)
+	}
+}
@@ -0,0 +1,46 @@
+package corona
+
+// CaseProvider provides the history of confirmed and recovered cases for countries.
+type CaseProvider interface {
+	// GetCases returns the confirmed and recovered case history of a country.
+	GetCases(country string) (confirmed, recovered CaseHistory, err *ServerError)
+	// GetCaseCountries returns the countries there is data for, by the names GetCases knows them by.
+	GetCaseCountries() ([]CaseCountry, *ServerError)
+	// Status returns the http status code of the upstream source, as reported by the diag endpoint.
+	Status() int
+}
+
+// StringencyProvider provides stringency data for countries.
+type StringencyProvider interface {
+	// GetStringency returns the stringency of a country, given by it's alpha3 code, at the given date (yyyy-mm-dd).
+	// The stringency is -1 if no data is available for that date.
+	GetStringency(code, date string) (float64, *ServerError)
+	// Status returns the http status code of the upstream source, as reported by the diag endpoint.
+	Status() int
+}
+
+// CountryProvider looks up information about countries.
+type CountryProvider interface {
+	// GetCountryCode returns the alpha3 code of the country with the given name.
+	GetCountryCode(name string) (string, *ServerError)
+	// GetCountries returns all the countries known upstream.
+	GetCountries() ([]Country, *ServerError)
+	// Status returns the http status code of the upstream source, as reported by the diag endpoint.
+	Status() int
+}
+
+// Providers is the set of upstream data sources the endpoints and webhooks get their data from.
+type Providers struct {
+	Cases      CaseProvider
+	Stringency StringencyProvider
+	Countries  CountryProvider
+}
+
+// NewHTTPProviders returns providers that fetch data from the public apis at their default root paths.
+func NewHTTPProviders() Providers {
+	return Providers{
+		Cases:      &MMediaGroupProvider{MMediaGroupAPIRootPath},
+		Stringency: &CovidTrackerProvider{CovidTrackerAPIRootPath},
+		Countries:  &RestCountriesProvider{RestCountriesRootPath},
+	}
+}
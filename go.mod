@@ -6,6 +6,9 @@ require (
 	cloud.google.com/go/firestore v1.1.1
 	firebase.google.com/go/v4 v4.3.0
 	github.com/go-chi/chi v1.5.4
+	github.com/gorilla/websocket v1.4.2
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/stretchr/testify v1.7.0
+	go.etcd.io/bbolt v1.3.5
 	google.golang.org/grpc v1.29.1
 )
@@ -0,0 +1,217 @@
+package notifications
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits on the change events kept by a Broker.
+const (
+	// BrokerHistorySize is how many of the latest events are kept, for subscribers that resume after missing some.
+	BrokerHistorySize int = 256
+	// subscriptionBuffer is how many events a subscriber can fall behind by before it is dropped.
+	subscriptionBuffer int = 64
+)
+
+// ChangeEvent is a change to the data of a field in a country, as detected by the notification engine.
+type ChangeEvent struct {
+	// ID increases with every event, so subscribers can tell the broker where to resume from.
+	ID      uint64      `json:"id"`
+	Country string      `json:"country"`
+	Field   string      `json:"field"`
+	Time    time.Time   `json:"time"`
+	Data    interface{} `json:"data"`
+}
+
+// Subscription is a subscriber to the change events of a Broker, for the countries and fields it follows.
+type Subscription struct {
+	// follows are the countries and fields the subscription is interested in, where empty means any.
+	// They are guarded by the mutex of the broker.
+	follows []pair
+	events  chan ChangeEvent
+}
+
+// Events delivers the events the subscription is interested in. It is closed if the subscriber falls too far behind,
+// or is unsubscribed.
+func (s *Subscription) Events() <-chan ChangeEvent {
+	return s.events
+}
+
+// matches returns true if the subscription is interested in the event.
+func (s *Subscription) matches(event *ChangeEvent) bool {
+	for _, p := range s.follows {
+		if (p.country == "" || strings.EqualFold(p.country, event.Country)) && (p.field == "" || p.field == event.Field) {
+			return true
+		}
+	}
+	return false
+}
+
+// following returns the index of a country and field the subscription follows, or -1.
+func (s *Subscription) following(country, field string) int {
+	for i, p := range s.follows {
+		if strings.EqualFold(p.country, country) && p.field == field {
+			return i
+		}
+	}
+	return -1
+}
+
+// Broker hands out the change events detected by the poller and scheduler to subscribers that are not webhooks,
+// like the event stream. A nil broker drops every event.
+type Broker struct {
+	mu sync.Mutex
+	// lastID is the id of the latest event. It starts out at the time the broker was created, in milliseconds,
+	// so that ids keep increasing across restarts, while staying small enough for JavaScript to represent exactly.
+	lastID      uint64
+	history     []ChangeEvent
+	subscribers map[*Subscription]struct{}
+}
+
+// NewBroker creates a broker without any subscribers.
+func NewBroker() *Broker {
+	return &Broker{
+		lastID:      uint64(time.Now().UnixNano() / int64(time.Millisecond)),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish hands a change to the data of a snapshot to all the interested subscribers.
+// Subscribers that have fallen too far behind are dropped, rather than holding up the notification engine.
+func (b *Broker) Publish(snapshot *Snapshot) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event := ChangeEvent{ID: b.lastID, Country: snapshot.Country, Field: snapshot.Field, Time: snapshot.Seen, Data: snapshot.Body()}
+
+	b.history = append(b.history, event)
+	if len(b.history) > BrokerHistorySize {
+		b.history = b.history[len(b.history)-BrokerHistorySize:]
+	}
+
+	for sub := range b.subscribers {
+		if !sub.matches(&event) {
+			continue
+		}
+
+		select {
+		case sub.events <- event:
+		default:
+			close(sub.events)
+			delete(b.subscribers, sub)
+		}
+	}
+}
+
+// Subscribe subscribes to the events of a country, with the given alpha3 code, and field, where empty means any.
+// If after is not 0, the events after it that are still kept are returned, to be handled before any new ones.
+func (b *Broker) Subscribe(country, code, field string, after uint64) (*Subscription, []ChangeEvent) {
+	follow := pair{country: country, field: field, code: code}
+	sub := &Subscription{follows: []pair{follow}, events: make(chan ChangeEvent, subscriptionBuffer)}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	missed := make([]ChangeEvent, 0)
+	if after != 0 {
+		for i := range b.history {
+			if b.history[i].ID > after && sub.matches(&b.history[i]) {
+				missed = append(missed, b.history[i])
+			}
+		}
+	}
+
+	b.subscribers[sub] = struct{}{}
+	return sub, missed
+}
+
+// Listen subscribes to nothing, until countries and fields are followed.
+func (b *Broker) Listen() *Subscription {
+	sub := &Subscription{events: make(chan ChangeEvent, subscriptionBuffer)}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Follow adds the events of a country, with the given alpha3 code, and field to a subscription.
+// Returns false if the subscription already follows them.
+func (b *Broker) Follow(sub *Subscription, country, code, field string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if sub.following(country, field) >= 0 {
+		return false
+	}
+	sub.follows = append(sub.follows, pair{country: country, field: field, code: code})
+	return true
+}
+
+// Unfollow removes the events of a country and field from a subscription.
+// Returns false if the subscription did not follow them.
+func (b *Broker) Unfollow(sub *Subscription, country, field string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i := sub.following(country, field)
+	if i < 0 {
+		return false
+	}
+	sub.follows = append(sub.follows[:i], sub.follows[i+1:]...)
+	return true
+}
+
+// Unsubscribe stops delivering events to the subscription, and closes its channel.
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// It might already have been dropped for falling behind
+	if _, ok := b.subscribers[sub]; ok {
+		close(sub.events)
+		delete(b.subscribers, sub)
+	}
+}
+
+// Follows returns the countries and fields the subscribers follow, so that the poller checks them for changes
+// even if no webhook is subscribed to them. A follow of any field is both fields of the country,
+// while a follow of any country can not be checked, and is left out.
+func (b *Broker) Follows() []pair {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	follows := make([]pair, 0)
+	for sub := range b.subscribers {
+		for _, p := range sub.follows {
+			switch {
+			case p.country == "":
+				continue
+			case p.field == "":
+				follows = append(follows, pair{p.country, FieldConfirmed, p.code}, pair{p.country, FieldStringency, p.code})
+			default:
+				follows = append(follows, p)
+			}
+		}
+	}
+	return follows
+}
+
+// Subscribers returns the number of current subscribers.
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.subscribers)
+}
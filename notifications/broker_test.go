@@ -0,0 +1,109 @@
+package notifications
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBrokerFilters tests that subscribers only get the events of the country and field they subscribed to.
+func TestBrokerFilters(t *testing.T) {
+	broker := NewBroker()
+	all, _ := broker.Subscribe("", "", "", 0)
+	norway, _ := broker.Subscribe("norway", "", FieldConfirmed, 0)
+
+	norwayConfirmed := exampleSnapshot("Norway", FieldConfirmed)
+	swedenConfirmed := exampleSnapshot("Sweden", FieldConfirmed)
+	norwayStringency := exampleSnapshot("Norway", FieldStringency)
+	broker.Publish(&norwayConfirmed)
+	broker.Publish(&swedenConfirmed)
+	broker.Publish(&norwayStringency)
+
+	assert.Len(t, all.Events(), 3)
+	assert.Len(t, norway.Events(), 1)
+	event := <-norway.Events()
+	assert.Equal(t, "Norway", event.Country)
+	assert.Equal(t, FieldConfirmed, event.Field)
+
+	broker.Unsubscribe(all)
+	broker.Unsubscribe(norway)
+	assert.Equal(t, 0, broker.Subscribers())
+	// Unsubscribing twice is harmless
+	broker.Unsubscribe(norway)
+}
+
+// TestBrokerResume tests that subscribers get the events after the one they resume from, in order.
+func TestBrokerResume(t *testing.T) {
+	broker := NewBroker()
+	snapshot := exampleSnapshot("Norway", FieldConfirmed)
+	other := exampleSnapshot("Sweden", FieldConfirmed)
+
+	first, _ := broker.Subscribe("", "", "", 0)
+	for i := 0; i < 3; i++ {
+		broker.Publish(&snapshot)
+		broker.Publish(&other)
+	}
+	resumeFrom := (<-first.Events()).ID
+
+	sub, missed := broker.Subscribe("Norway", "", "", resumeFrom)
+	defer broker.Unsubscribe(sub)
+	if assert.Len(t, missed, 2) {
+		assert.Greater(t, missed[0].ID, resumeFrom)
+		assert.Greater(t, missed[1].ID, missed[0].ID)
+		assert.Equal(t, "Norway", missed[1].Country)
+	}
+
+	// Without an id to resume from, only new events are delivered
+	_, missed = broker.Subscribe("", "", "", 0)
+	assert.Empty(t, missed)
+
+	// Browsers parse the ids as doubles, which are only exact up to 2^53
+	assert.Less(t, broker.lastID, uint64(1)<<53)
+}
+
+// TestBrokerDropsSlowSubscribers tests that a subscriber that falls behind is dropped, without holding up the others.
+func TestBrokerDropsSlowSubscribers(t *testing.T) {
+	broker := NewBroker()
+	slow, _ := broker.Subscribe("", "", "", 0)
+	snapshot := exampleSnapshot("Norway", FieldConfirmed)
+
+	for i := 0; i <= subscriptionBuffer; i++ {
+		broker.Publish(&snapshot)
+	}
+
+	assert.Equal(t, 0, broker.Subscribers())
+	n := 0
+	for range slow.Events() {
+		n++
+	}
+	assert.Equal(t, subscriptionBuffer, n)
+
+	// A nil broker drops every event
+	var none *Broker
+	none.Publish(&snapshot)
+}
+
+// TestBrokerFollow tests that a subscription gets the events of the countries and fields it follows, and only those.
+func TestBrokerFollow(t *testing.T) {
+	broker := NewBroker()
+	sub := broker.Listen()
+	defer broker.Unsubscribe(sub)
+
+	norway := exampleSnapshot("Norway", FieldConfirmed)
+	sweden := exampleSnapshot("Sweden", FieldStringency)
+	broker.Publish(&norway)
+	assert.Len(t, sub.Events(), 0)
+
+	assert.True(t, broker.Follow(sub, "Norway", "", FieldConfirmed))
+	assert.False(t, broker.Follow(sub, "norway", "", FieldConfirmed))
+	assert.True(t, broker.Follow(sub, "Sweden", "", FieldStringency))
+	broker.Publish(&norway)
+	broker.Publish(&sweden)
+	assert.Len(t, sub.Events(), 2)
+
+	assert.True(t, broker.Unfollow(sub, "Norway", FieldConfirmed))
+	assert.False(t, broker.Unfollow(sub, "Norway", FieldConfirmed))
+	broker.Publish(&norway)
+	broker.Publish(&sweden)
+	assert.Len(t, sub.Events(), 3)
+}
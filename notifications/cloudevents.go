@@ -0,0 +1,103 @@
+package notifications
+
+import (
+	"strings"
+	"time"
+)
+
+// Formats a webhook can have its deliveries in.
+const (
+	// FormatRaw deliveries are the data itself, with no envelope. Webhooks without a format use it.
+	FormatRaw string = "raw"
+	// FormatCloudEvents deliveries are CloudEvents 1.0 in structured JSON mode, with the data in the data attribute.
+	FormatCloudEvents string = "cloudevents"
+)
+
+// CloudEvents constants, see https://github.com/cloudevents/spec/blob/v1.0/spec.md.
+const (
+	CloudEventsVersion     string = "1.0"
+	CloudEventsContentType string = "application/cloudevents+json; charset=utf-8"
+	// CloudEventsTypePrefix prefixes the type of every event, which is followed by the field and what happened,
+	// like corona.confirmed.changed.
+	CloudEventsTypePrefix string = "corona."
+)
+
+// eventNames is what happened, as part of the event type, for each reason a delivery is made.
+var eventNames = map[string]string{
+	ReasonTimeout:   "timeout",
+	ReasonChange:    "changed",
+	ReasonCondition: "condition",
+	ReasonReplay:    "replayed",
+	ReasonTest:      "test",
+}
+
+// Event identifies what a delivery is about. It stays the same when the delivery is retried or replayed,
+// so that receivers can tell when they have seen it before.
+type Event struct {
+	ID string `json:"id"`
+	// Reason is why the event was first delivered, one of the Reason constants.
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"time"`
+}
+
+// newEvent creates an event that happened now, for reason.
+func newEvent(reason string) Event {
+	return Event{ID: NewID(), Reason: reason, Time: time.Now()}
+}
+
+// CloudEvent is a delivery in the FormatCloudEvents format.
+type CloudEvent struct {
+	SpecVersion     string `json:"specversion"`
+	ID              string `json:"id"`
+	Source          string `json:"source"`
+	Type            string `json:"type"`
+	Subject         string `json:"subject,omitempty"`
+	Time            string `json:"time"`
+	DataContentType string `json:"datacontenttype"`
+	// Data is what would have been delivered in the FormatRaw format.
+	Data interface{} `json:"data"`
+}
+
+// validateFormat checks that the format of a webhook is one of the Format constants, or empty.
+func validateFormat(webhook *Webhook) error {
+	switch webhook.Format {
+	case "", FormatRaw, FormatCloudEvents:
+		return nil
+	}
+	return validationError("The format must be either raw or cloudevents")
+}
+
+// cloudEvent wraps the data of the delivery in a CloudEvent.
+// Deliveries of a single field are typed by that field, and combined deliveries as corona.combined.
+// The subject is the country, unless the delivery is about several countries.
+func (d *Delivery) cloudEvent() *CloudEvent {
+	field, country := "combined", ""
+	if !d.Webhook.multi() && len(d.Snapshots) == 1 {
+		field = d.Snapshots[0].Field
+	}
+	for i := range d.Snapshots {
+		if i == 0 {
+			country = d.Snapshots[i].Country
+		} else if !strings.EqualFold(country, d.Snapshots[i].Country) {
+			country = ""
+			break
+		}
+	}
+
+	event := d.Event
+	name, ok := eventNames[event.Reason]
+	if !ok {
+		name = strings.ToLower(event.Reason)
+	}
+
+	return &CloudEvent{
+		SpecVersion:     CloudEventsVersion,
+		ID:              event.ID,
+		Source:          RootPath + "/" + d.Webhook.ID,
+		Type:            CloudEventsTypePrefix + field + "." + name,
+		Subject:         country,
+		Time:            event.Time.UTC().Format(time.RFC3339),
+		DataContentType: "application/json",
+		Data:            d.body(),
+	}
+}
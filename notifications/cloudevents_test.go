@@ -0,0 +1,66 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCloudEvents tests that webhooks in the cloudevents format get their data wrapped in a CloudEvent,
+// which keeps its id when the delivery is retried.
+func TestCloudEvents(t *testing.T) {
+	type received struct {
+		contentType string
+		event       CloudEvent
+	}
+	var attempts int32
+	events := make(chan received, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var event CloudEvent
+		_ = json.NewDecoder(r.Body).Decode(&event)
+		events <- received{r.Header.Get("Content-Type"), event}
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable) // Fail the first attempt, so it is retried
+		}
+	}))
+	defer receiver.Close()
+
+	store := NewMemoryStore()
+	dispatcher := startDispatcher(t, store, fastRetries())
+	webhook := Webhook{URL: receiver.URL, Format: FormatCloudEvents}
+	webhook.ID, _ = store.Create(context.Background(), &webhook)
+	snapshot := Snapshot{Country: "Norway", Field: FieldConfirmed, Confirmed: corona.CountryResponse{Country: "Norway", Confirmed: 100}}
+	dispatcher.Dispatch(&webhook, &snapshot, ReasonChange)
+
+	first, retried := <-events, <-events
+	assert.Equal(t, CloudEventsContentType, first.contentType)
+	event := first.event
+	assert.Equal(t, CloudEventsVersion, event.SpecVersion)
+	assert.NotEmpty(t, event.ID)
+	assert.Equal(t, RootPath+"/"+webhook.ID, event.Source)
+	assert.Equal(t, "corona.confirmed.changed", event.Type)
+	assert.Equal(t, "Norway", event.Subject)
+	assert.NotEmpty(t, event.Time)
+	assert.Equal(t, "application/json", event.DataContentType)
+	assert.Equal(t, 100.0, event.Data.(map[string]interface{})["confirmed"])
+	assert.Equal(t, event.ID, retried.event.ID, "A retry is the same event")
+
+	// Combined deliveries about several countries have no subject
+	combined := Delivery{
+		Webhook:   Webhook{Countries: []string{"Norway", "Sweden"}, Field: FieldConfirmed},
+		Snapshots: []Snapshot{{Country: "Norway", Field: FieldConfirmed}, {Country: "Sweden", Field: FieldConfirmed}},
+		Event:     newEvent(ReasonTimeout),
+	}
+	assert.Equal(t, "corona.combined.timeout", combined.cloudEvent().Type)
+	assert.Empty(t, combined.cloudEvent().Subject)
+
+	assert.NoError(t, validateFormat(&Webhook{}))
+	assert.NoError(t, validateFormat(&Webhook{Format: FormatRaw}))
+	assert.Error(t, validateFormat(&Webhook{Format: "xml"}))
+}
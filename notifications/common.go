@@ -1,5 +1,13 @@
 package notifications
 
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// idLength is the number of random bytes in an id generated by NewID.
+const idLength int = 10
+
 // Contains returns true if the array a contains the string x, and false otherwise.
 func Contains(a []string, x string) bool {
 	for _, s := range a {
@@ -9,3 +17,15 @@ func Contains(a []string, x string) bool {
 	}
 	return false
 }
+
+// randomHex generates n random bytes, encoded as hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b) // crypto/rand only fails if the os has no source of randomness, at which point all bets are off
+	return hex.EncodeToString(b)
+}
+
+// NewID generates a random id, looking roughly like the ones firestore generates for documents.
+func NewID() string {
+	return randomHex(idLength)
+}
@@ -0,0 +1,150 @@
+package notifications
+
+import (
+	"context"
+)
+
+// Metrics a condition can look at.
+const (
+	MetricConfirmed            string = "confirmed"
+	MetricRecovered            string = "recovered"
+	MetricPopulationPercentage string = "population_percentage"
+	MetricStringency           string = "stringency"
+)
+
+// metricFields maps each metric to the field its data comes from.
+var metricFields = map[string]string{
+	MetricConfirmed:            FieldConfirmed,
+	MetricRecovered:            FieldConfirmed,
+	MetricPopulationPercentage: FieldConfirmed,
+	MetricStringency:           FieldStringency,
+}
+
+// Operators a condition can compare a metric with.
+const (
+	// OperatorAbove is met when the metric crosses from at or below the value to above it.
+	OperatorAbove string = "above"
+	// OperatorBelow is met when the metric crosses from at or above the value to below it.
+	OperatorBelow string = "below"
+	// OperatorIncreasePercent is met when the metric has increased by more than value percent since the last notification.
+	OperatorIncreasePercent string = "increase_percent"
+	// OperatorDecreasePercent is met when the metric has decreased by more than value percent since the last notification.
+	OperatorDecreasePercent string = "decrease_percent"
+)
+
+// Condition is what an ON_CONDITION webhook waits for, declared in the registration body.
+type Condition struct {
+	// Metric is one of the Metric constants.
+	Metric string `json:"metric"`
+	// Operator is one of the Operator constants.
+	Operator string  `json:"operator"`
+	Value    float64 `json:"value"`
+	// Reference is what the next value of the metric is compared against, maintained by the notification engine.
+	// For above and below it is the value last seen, for the percentages it is the value at the last notification.
+	Reference *float64 `json:"reference,omitempty"`
+}
+
+// validate checks that the condition is well formed, and that it looks at data from field.
+func (c *Condition) validate(field string) error {
+	metricField, ok := metricFields[c.Metric]
+	if !ok {
+		return validationError("The condition metric must be one of confirmed, recovered, population_percentage or stringency")
+	}
+	if metricField != field {
+		return validationError("The condition metric " + c.Metric + " is not part of the field " + field)
+	}
+
+	switch c.Operator {
+	case OperatorAbove, OperatorBelow:
+	case OperatorIncreasePercent, OperatorDecreasePercent:
+		if c.Value <= 0 {
+			return validationError("The condition value must be a positive percentage")
+		}
+	default:
+		return validationError("The condition operator must be one of above, below, increase_percent or decrease_percent")
+	}
+
+	return nil
+}
+
+// metric returns the value of the condition's metric in the snapshot.
+// Returns false if the snapshot has no data for it.
+func (c *Condition) metric(snapshot *Snapshot) (float64, bool) {
+	switch c.Metric {
+	case MetricConfirmed:
+		return snapshot.Confirmed.Confirmed, snapshot.Field == FieldConfirmed
+	case MetricRecovered:
+		return snapshot.Confirmed.Recovered, snapshot.Field == FieldConfirmed
+	case MetricPopulationPercentage:
+		return snapshot.Confirmed.PopulationPercentage, snapshot.Field == FieldConfirmed
+	case MetricStringency:
+		// Stringency is reported as -1 when there is no data
+		return snapshot.Stringency.Stringency, snapshot.Field == FieldStringency && snapshot.Stringency.Stringency >= 0
+	}
+
+	return 0, false
+}
+
+// check returns whether the condition is met by value, and what to compare the next value against.
+func (c *Condition) check(value float64) (bool, float64) {
+	ref := c.Reference
+
+	switch c.Operator {
+	case OperatorAbove:
+		// Without a reference, the metric has never been seen above the value, so being above it is crossing it
+		return value > c.Value && (ref == nil || *ref <= c.Value), value
+	case OperatorBelow:
+		return value < c.Value && (ref == nil || *ref >= c.Value), value
+	}
+
+	// The percentages are relative to the last notification, and there is none the first time
+	if ref == nil {
+		return false, value
+	}
+
+	//nolint:gomnd // Percent
+	threshold := *ref * c.Value / 100
+	if threshold < 0 {
+		threshold = -threshold
+	}
+
+	met := false
+	if c.Operator == OperatorIncreasePercent {
+		met = value > *ref && value-*ref > threshold
+	} else { // c.Operator == OperatorDecreasePercent
+		met = value < *ref && *ref-value > threshold
+	}
+
+	if met {
+		return true, value
+	}
+	return false, *ref
+}
+
+// Evaluate checks the condition of a webhook against fresh data, and returns whether the webhook should be notified.
+// The condition is checked against what is in the store, and its reference is updated in the same operation,
+// so the same change can not notify the webhook twice.
+func Evaluate(ctx context.Context, store WebhookStore, webhook *Webhook, snapshot *Snapshot) (bool, error) {
+	if webhook.Condition == nil {
+		return false, nil
+	}
+	value, ok := webhook.Condition.metric(snapshot)
+	if !ok {
+		return false, nil
+	}
+
+	met := false
+	_, err := store.Update(ctx, webhook.ID, func(stored *Webhook) error {
+		met = false // The update might be retried
+		if stored.Condition == nil {
+			return nil
+		}
+
+		var reference float64
+		met, reference = stored.Condition.check(value)
+		stored.Condition.Reference = &reference
+		return nil
+	})
+
+	return met, err
+}
@@ -0,0 +1,73 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestConditionCheck tests that each operator fires once per crossing or per percentage since the last notification.
+func TestConditionCheck(t *testing.T) {
+	tests := []struct {
+		operator string
+		value    float64
+		values   []float64
+		met      []bool
+	}{
+		{OperatorAbove, 50, []float64{60, 70, 40, 55}, []bool{true, false, false, true}},
+		{OperatorBelow, 50, []float64{60, 40, 30, 55, 45}, []bool{false, true, false, false, true}},
+		{OperatorIncreasePercent, 10, []float64{100, 105, 111, 120, 123}, []bool{false, false, true, false, true}},
+		{OperatorDecreasePercent, 10, []float64{100, 95, 89, 110}, []bool{false, false, true, false}},
+	}
+
+	for _, test := range tests {
+		condition := Condition{Metric: MetricConfirmed, Operator: test.operator, Value: test.value}
+		met := make([]bool, 0, len(test.values))
+		for _, value := range test.values {
+			ok, reference := condition.check(value)
+			condition.Reference = &reference
+			met = append(met, ok)
+		}
+		assert.Equal(t, test.met, met, "%s %v for %v", test.operator, test.value, test.values)
+	}
+}
+
+// TestConditionValidate tests that conditions must look at the field of the webhook, and use known operators.
+func TestConditionValidate(t *testing.T) {
+	assert.NoError(t, (&Condition{Metric: MetricPopulationPercentage, Operator: OperatorAbove, Value: 0.1}).validate(FieldConfirmed))
+	assert.NoError(t, (&Condition{Metric: MetricStringency, Operator: OperatorBelow, Value: 40}).validate(FieldStringency))
+	assert.Error(t, (&Condition{Metric: MetricStringency, Operator: OperatorBelow, Value: 40}).validate(FieldConfirmed))
+	assert.Error(t, (&Condition{Metric: "deaths", Operator: OperatorAbove}).validate(FieldConfirmed))
+	assert.Error(t, (&Condition{Metric: MetricConfirmed, Operator: "equals"}).validate(FieldConfirmed))
+	assert.Error(t, (&Condition{Metric: MetricConfirmed, Operator: OperatorIncreasePercent, Value: -5}).validate(FieldConfirmed))
+}
+
+// TestEvaluate tests that the reference is kept in the store, so the same data does not meet a condition twice.
+func TestEvaluate(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	webhook := Webhook{
+		Field:     FieldStringency,
+		Trigger:   TriggerOnCondition,
+		Condition: &Condition{Metric: MetricStringency, Operator: OperatorAbove, Value: 50},
+	}
+	webhook.ID, _ = store.Create(ctx, &webhook)
+
+	snapshot := &Snapshot{Field: FieldStringency, Stringency: corona.PolicyResponse{Stringency: 60}}
+	met, err := Evaluate(ctx, store, &webhook, snapshot)
+	assert.NoError(t, err)
+	assert.True(t, met)
+
+	met, _ = Evaluate(ctx, store, &webhook, snapshot)
+	assert.False(t, met, "Staying above the value is not crossing it again")
+
+	stored, _ := store.Get(ctx, webhook.ID)
+	if assert.NotNil(t, stored.Condition.Reference) {
+		assert.Equal(t, 60.0, *stored.Condition.Reference)
+	}
+
+	met, _ = Evaluate(ctx, store, &webhook, &Snapshot{Field: FieldStringency, Stringency: corona.PolicyResponse{Stringency: -1}})
+	assert.False(t, met, "Missing stringency data should not be evaluated")
+}
@@ -8,11 +8,45 @@ const (
 	// RootPath of notifications endpoints.
 	RootPath string = corona.RootPath + "/notifications"
 
+	// StreamPath is the path of the event stream of changes to the data, which is not under RootPath.
+	StreamPath string = corona.RootPath + "/stream"
+
+	// SocketPath is the path of the websocket endpoint for following changes to the data, which is not under RootPath.
+	SocketPath string = corona.RootPath + "/socket"
+
 	// IdPattern is the path of any endpoint that takes one id parameter and otherwise is defined by it's http method.
 	IDPattern string = "/{id}"
 
+	// DeadLettersPath is the path of the endpoints for deliveries that failed even after being retried.
+	DeadLettersPath string = "/deadletters"
+
+	// DeliveriesPath is the path of the delivery history of a webhook, relative to IDPattern.
+	DeliveriesPath string = "/deliveries"
+
+	// SecretPath is the path of the endpoint that rotates the secret of a webhook, relative to IDPattern.
+	SecretPath string = "/secret"
+
+	// PausePath and ResumePath are the paths of the endpoints that pause and resume a webhook, relative to IDPattern.
+	PausePath  string = "/pause"
+	ResumePath string = "/resume"
+
+	// TestPath is the path of the endpoint that sends a test delivery to a webhook, relative to IDPattern.
+	TestPath string = "/test"
+
+	// VerifyPath is the path of the endpoint that retries the verification of a pending webhook, relative to IDPattern.
+	VerifyPath string = "/verify"
+
 	// WebhookCollection is the firestore collection that contains all the webhooks currently registered.
 	WebhookCollection string = "webhooks"
+
+	// SnapshotCollection is the firestore collection that contains the last data seen for each country and field.
+	SnapshotCollection string = "snapshots"
+
+	// DeadLetterCollection is the firestore collection that contains deliveries that failed even after being retried.
+	DeadLetterCollection string = "deadletters"
+
+	// DeliveryCollection is the firestore collection, under each webhook, that contains the history of deliveries to it.
+	DeliveryCollection string = "deliveries"
 )
 
 // Triggers that a webhook waits for.
@@ -21,6 +55,22 @@ const (
 	TriggerOnTimeout string = "ON_TIMEOUT"
 	// TriggerOnChange is triggered when the latest data on the field the webhook is interested in changes.
 	TriggerOnChange string = "ON_CHANGE"
+	// TriggerOnCondition is triggered when the latest data on the field the webhook is interested in meets its Condition.
+	TriggerOnCondition string = "ON_CONDITION"
+)
+
+// Reasons a delivery was made, as recorded in the delivery history.
+const (
+	// ReasonTimeout is a delivery made because the timeout of the webhook expired.
+	ReasonTimeout string = TriggerOnTimeout
+	// ReasonChange is a delivery made because the data the webhook is interested in changed.
+	ReasonChange string = TriggerOnChange
+	// ReasonCondition is a delivery made because the data the webhook is interested in met its condition.
+	ReasonCondition string = TriggerOnCondition
+	// ReasonReplay is a delivery made by replaying a dead letter.
+	ReasonReplay string = "REPLAY"
+	// ReasonTest is a delivery made on request, to test the webhook.
+	ReasonTest string = "TEST"
 )
 
 // Fields that a webhook cares about.
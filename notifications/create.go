@@ -2,71 +2,192 @@ package notifications
 
 import (
 	"assignment-2/corona"
-	"cloud.google.com/go/firestore"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"time"
 )
 
+// MaxBodyLength is the largest request body, in bytes, accepted when registering or updating a webhook.
+// It leaves plenty of room for a template and a list of every country.
+const MaxBodyLength int64 = 64 * 1024
+
+// validationError is a problem with a webhook, worded to be shown to the client.
+type validationError string
+
+func (e validationError) Error() string { return string(e) }
+
+// validateURL checks that the url is allowed by the egress policy,
+// and sends an OPTIONS request to the url, using client, in order to check:
+// 1. That the url exits.
+// 2. That the url accepts POST requests.
+func validateURL(egress *EgressPolicy, client *http.Client, url string) error {
+	err := egress.CheckURL(url)
+	if err != nil {
+		return validationError("The url is not allowed; " + err.Error())
+	}
+
+	status := corona.GetStatusWith(client, url)
+	if !corona.StatusIs2XX(status) {
+		log.Println("Status of", url, status)
+		return validationError("There is something wrong with the url field")
+	}
+
+	return nil
+}
+
+// validate checks that the fields of a webhook, other than the url, are valid.
+func validate(webhook *Webhook) error {
+	// Check if the timeout or schedule is valid
+	err := validateSchedule(webhook)
+	if err != nil {
+		return err
+	}
+
+	// Check if the countries and fields are valid
+	err = validateSubscription(webhook)
+	if err != nil {
+		return err
+	}
+
+	// Check if the trigger is valid
+	switch webhook.Trigger {
+	case TriggerOnChange, TriggerOnTimeout:
+		if webhook.Condition != nil {
+			return validationError("A condition can only be used with the ON_CONDITION trigger")
+		}
+	case TriggerOnCondition:
+		if webhook.Condition == nil {
+			return validationError("The ON_CONDITION trigger requires a condition")
+		}
+		err = webhook.Condition.validate(webhook.Field)
+		if err != nil {
+			return err
+		}
+	default:
+		return validationError("The trigger supplied does not exits")
+	}
+
+	err = validateFormat(webhook)
+	if err != nil {
+		return err
+	}
+
+	err = validateTemplate(webhook)
+	if err != nil {
+		return err
+	}
+
+	if webhook.MaxInvocations < 0 {
+		return validationError("The max invocations must be a positive number, or 0 for no limit")
+	}
+
+	return nil
+}
+
 // responseBody is the body of the response sent back from the webhook creation endpoint.
+// The secret is only ever shown here, so the receiver has to hold on to it to verify deliveries.
+// The state is pending if the receiver failed the verification challenge, for the reason given by Verification.
 type responseBody struct {
-	ID string `json:"id"`
+	ID           string `json:"id"`
+	Secret       string `json:"secret"`
+	State        string `json:"state"`
+	Verification string `json:"verification_error,omitempty"`
 }
 
-func NewCreateHandler(fs *firestore.Client, registerChan chan<- string) http.HandlerFunc {
+// NewCreateHandler creates a HttpHandler that validates and registers a new webhook.
+// Requests to the receiver are limited by egress, which allows anything if nil.
+func NewCreateHandler(store WebhookStore, providers corona.Providers, scheduler *Scheduler, egress *EgressPolicy) http.HandlerFunc {
+	client := egress.Client(VerificationTimeout)
 	return func(rw http.ResponseWriter, r *http.Request) {
 		// Validation:
 		// - Send OPTIONS request to provided url and check if is exists and accepts POST requests
-		// - Check the field is one of the enumerated options
+		// - Once registered, challenge the receiver to prove it wants the deliveries
+		// - Check the timeout is positive, or the schedule is a valid cron expression
+		// - Check there is a country or list of countries that exist, and the fields are among the enumerated options
 		// - Check the trigger is one of the enumerated options
 
 		// Decode the request body into a struct
 		var body Webhook
-		err := json.NewDecoder(r.Body).Decode(&body)
+		err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, MaxBodyLength)).Decode(&body)
 		if err != nil {
 			http.Error(rw, "Failed to parse request body", http.StatusBadRequest)
 			return
 		}
 
-		// Send an OPTIONS request to the supplied url in order to check:
-		// 1. That the url exits.
-		// 2. That the url accepts POST requests.
-		status := corona.GetStatusOf(body.URL)
-		if !corona.StatusIs2XX(status) {
-			log.Println("Status of", body.URL, status)
-			http.Error(rw, "There is something wrong with the url field", http.StatusBadRequest)
-			return
+		// The field can be left out when it is given by the condition
+		if body.Condition != nil {
+			body.Condition.Reference = nil
+			if body.Field == "" {
+				body.Field = metricFields[body.Condition.Metric]
+			}
 		}
 
-		// Check if the field is valid
-		if body.Field != FieldStringency && body.Field != FieldConfirmed {
-			http.Error(rw, "The field supplied does not exits", http.StatusBadRequest)
+		// A continent is stored as the countries in it, since those rarely change
+		if body.Continent != "" {
+			body.Countries, err = continentCountries(providers, body.Continent)
+		}
+		// Look up the countries now, so that a typo is not only discovered once the webhook is invoked
+		if err == nil {
+			err = resolveCountries(providers, &body)
+		}
+		if err != nil {
+			if !writeError(rw, err) {
+				log.Println(err.Error())
+				http.Error(rw, "Something went wrong trying to look up the countries", http.StatusInternalServerError)
+			}
 			return
 		}
 
-		// Check if the trigger is valid
-		if body.Trigger != TriggerOnChange && body.Trigger != TriggerOnTimeout {
-			http.Error(rw, "The trigger supplied does not exits", http.StatusBadRequest)
+		err = validateURL(egress, client, body.URL)
+		if err == nil {
+			err = validate(&body)
+		}
+		if err == nil {
+			err = validateExpires(body.Expires)
+		}
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
 			return
 		}
 
 		// Keep track of when to time the webhook out
 		body.LastTriggered = time.Now()
 
+		// Generate the secret the deliveries are signed with, ignoring anything the client tried to set
+		body.Secret = NewSecret()
+		body.PreviousSecret, body.PreviousSecretExpires = "", nil
+
+		// New webhooks wait for their receiver to be verified, no matter what the client says
+		body.State, body.Paused = StatePending, false
+		body.Invocations = 0
+		body.NextRun = nil
+
 		// Now actually create / register the webhook
-		docref, _, err := fs.Collection(WebhookCollection).Add(r.Context(), body)
+		id, err := store.Create(r.Context(), &body)
 		if err != nil {
-			log.Println("Failed to add to firestore collection", err)
+			log.Println("Failed to register webhook", err)
 			http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 
-		// Notify the invocation loop that a new webhook has been registered
-		registerChan <- "register"
+		// Verify the receiver, which activates the webhook and notifies the scheduler about it.
+		// A receiver that fails is told why, and can be verified again later.
+		body.ID = id
+		response := responseBody{ID: id, Secret: body.Secret, State: StatePending}
+		webhook, err := verify(r.Context(), store, scheduler, client, &body)
+		var failed *challengeError
+		if errors.As(err, &failed) {
+			response.Verification = failed.Error()
+		} else if err != nil {
+			log.Println("Failed to activate webhook", id, err.Error())
+			response.Verification = "Something went wrong trying to activate the webhook"
+		} else {
+			response.State = webhook.State
+		}
 
-		response := responseBody{docref.ID}
-		_ = json.NewEncoder(rw).Encode(response)
 		rw.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(rw).Encode(response)
 	}
 }
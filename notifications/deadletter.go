@@ -0,0 +1,137 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// ErrDeadLetterNotFound is returned by a DeadLetterStore when there is no dead letter by the given id.
+var ErrDeadLetterNotFound = errors.New("no dead letter by that id")
+
+// DeadLetter is a delivery that failed even after being retried.
+type DeadLetter struct {
+	// ID is assigned by the DeadLetterStore, and is not stored as part of the document itself.
+	ID        string `json:"id" firestore:"-"`
+	WebhookID string `json:"webhook_id"`
+	// Snapshots is the data that failed to be delivered.
+	Snapshots []Snapshot `json:"snapshots"`
+	// Snapshot is where the data was kept by dead letters from before webhooks could subscribe to several countries.
+	Snapshot *Snapshot `json:"snapshot,omitempty"`
+	// Event is what failed to be delivered, which a replay delivers again.
+	Event    Event `json:"event"`
+	Attempts int   `json:"attempts"`
+	// Error and StatusCode describe why the last attempt failed. StatusCode is 0 if the receiver never replied.
+	Error      string    `json:"error"`
+	StatusCode int       `json:"status_code"`
+	Failed     time.Time `json:"failed"`
+}
+
+// DeadLetterStore is where deliveries end up after running out of retries, until they are replayed or discarded.
+type DeadLetterStore interface {
+	// AddDeadLetter stores a failed delivery and returns the id it was given.
+	AddDeadLetter(ctx context.Context, letter *DeadLetter) (string, error)
+	// GetDeadLetter returns the dead letter by id, or ErrDeadLetterNotFound.
+	GetDeadLetter(ctx context.Context, id string) (*DeadLetter, error)
+	// ListDeadLetters returns all the dead letters with their ID field filled out.
+	ListDeadLetters(ctx context.Context) ([]DeadLetter, error)
+	// DeleteDeadLetter removes the dead letter by id, or returns ErrDeadLetterNotFound.
+	DeleteDeadLetter(ctx context.Context, id string) error
+}
+
+// NewDeadLettersHandler creates a HttpHandler that lists all the deliveries that failed.
+func NewDeadLettersHandler(store WebhookStore) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		body, err := store.ListDeadLetters(r.Context())
+		if err != nil {
+			log.Println(err.Error())
+			http.Error(rw, "Something went wrong trying to get the dead letters", http.StatusInternalServerError)
+			return
+		}
+
+		_ = json.NewEncoder(rw).Encode(&body)
+	}
+}
+
+// NewReplayHandler creates a HttpHandler that, given a dead letter id, dispatches the delivery again
+// to the current version of the webhook, and removes it from the dead letters.
+func NewReplayHandler(store WebhookStore, dispatcher *Dispatcher) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		letter, err := store.GetDeadLetter(r.Context(), id)
+		if errors.Is(err, ErrDeadLetterNotFound) {
+			http.Error(rw, "Invalid dead letter id; No dead letter by that id", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Println(err.Error())
+			http.Error(rw, "Something went wrong trying to get the dead letter", http.StatusInternalServerError)
+			return
+		}
+
+		webhook, err := store.Get(r.Context(), letter.WebhookID)
+		if errors.Is(err, ErrWebhookNotFound) {
+			http.Error(rw, "The webhook of the dead letter has been deleted, and can not be replayed", http.StatusGone)
+			return
+		} else if err != nil {
+			log.Println(err.Error())
+			http.Error(rw, "Something went wrong trying to get the webhook", http.StatusInternalServerError)
+			return
+		}
+
+		// The url of the webhook may have changed since, to one that has not been verified
+		if webhook.State == StatePending {
+			http.Error(rw, "The webhook has not been verified, and can not be replayed to until it is", http.StatusConflict)
+			return
+		}
+
+		// If it fails again, it ends up back in the dead letters under a new id
+		log.Println("Replaying dead letter:", id)
+		snapshots := letter.Snapshots
+		if len(snapshots) == 0 && letter.Snapshot != nil {
+			snapshots = []Snapshot{*letter.Snapshot}
+		}
+		// Dead letters from before events were introduced have none, so they are replayed as a new one
+		event := letter.Event
+		if event.ID == "" {
+			event = newEvent(ReasonReplay)
+		}
+		err = dispatcher.enqueue(Delivery{Webhook: *webhook, Snapshots: snapshots, Reason: ReasonReplay, Event: event})
+		if err != nil {
+			// The dead letter is kept, so that it can be replayed once there is room
+			log.Println(err.Error())
+			http.Error(rw, "The delivery could not be queued, try again later", http.StatusServiceUnavailable)
+			return
+		}
+
+		err = store.DeleteDeadLetter(r.Context(), id)
+		if err != nil && !errors.Is(err, ErrDeadLetterNotFound) {
+			log.Println("Failed to delete replayed dead letter", id, err.Error())
+		}
+		rw.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// NewDeadLetterDeleteHandler creates a HttpHandler that, given a dead letter id, discards it.
+func NewDeadLetterDeleteHandler(store WebhookStore) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		err := store.DeleteDeadLetter(r.Context(), id)
+		if errors.Is(err, ErrDeadLetterNotFound) {
+			http.Error(rw, "Invalid dead letter id; No dead letter by that id", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Println(err.Error())
+			http.Error(rw, "Something went wrong when deleting the dead letter.", http.StatusInternalServerError)
+			return
+		}
+
+		rw.WriteHeader(http.StatusNoContent)
+	}
+}
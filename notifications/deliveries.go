@@ -0,0 +1,110 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// DeliveryHistoryLimit is how many delivery attempts are kept per webhook. Older attempts are discarded.
+const DeliveryHistoryLimit int = 100
+
+// Page sizes of the delivery history endpoint.
+const (
+	defaultDeliveriesLimit int = 20
+	maxDeliveriesLimit     int = DeliveryHistoryLimit
+)
+
+// DeliveryRecord is the outcome of one attempt at delivering data to a webhook.
+type DeliveryRecord struct {
+	WebhookID string    `json:"webhook_id"`
+	Time      time.Time `json:"time"`
+	// Reason is why the delivery was made, one of the Reason constants.
+	Reason  string `json:"reason"`
+	Attempt int    `json:"attempt"`
+	// StatusCode is the status the receiver replied with, or 0 if it never replied.
+	StatusCode int `json:"status_code"`
+	// Latency is how long the receiver took to reply, in milliseconds.
+	Latency int64  `json:"latency_ms"`
+	Error   string `json:"error,omitempty"`
+	// PayloadHash is the hex encoded SHA-256 of the body that was posted.
+	PayloadHash string `json:"payload_hash"`
+}
+
+// DeliveryLog is where the history of delivery attempts to each webhook is kept.
+// Only the most recent DeliveryHistoryLimit attempts are kept, and the history is removed along with the webhook.
+type DeliveryLog interface {
+	// AddDelivery records an attempt at delivering to the record's webhook.
+	// Nothing is recorded if the webhook has been deleted since the attempt was made.
+	AddDelivery(ctx context.Context, record *DeliveryRecord) error
+	// ListDeliveries returns up to limit attempts at delivering to a webhook, newest first, skipping the first offset.
+	// The total number of attempts recorded for the webhook is returned as well.
+	ListDeliveries(ctx context.Context, webhookID string, offset, limit int) ([]DeliveryRecord, int, error)
+}
+
+// deliveriesPage is the body of the delivery history endpoint.
+type deliveriesPage struct {
+	Total      int              `json:"total"`
+	Offset     int              `json:"offset"`
+	Limit      int              `json:"limit"`
+	Deliveries []DeliveryRecord `json:"deliveries"`
+}
+
+// intQuery reads a non-negative integer from the query parameter by name, or returns fallback if it is not set.
+func intQuery(r *http.Request, name string, fallback int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, errors.New(name + " must be a non-negative integer")
+	}
+
+	return n, nil
+}
+
+// NewDeliveriesHandler creates a HttpHandler that, given a webhook id, returns the recent attempts at delivering to it.
+// The attempts are paginated using the offset and limit query parameters.
+func NewDeliveriesHandler(store WebhookStore) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		offset, err := intQuery(r, "offset", 0)
+		if err != nil {
+			http.Error(rw, "Invalid offset; "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit, err := intQuery(r, "limit", defaultDeliveriesLimit)
+		if err != nil || limit == 0 || limit > maxDeliveriesLimit {
+			http.Error(rw, "Invalid limit; limit must be between 1 and "+strconv.Itoa(maxDeliveriesLimit), http.StatusBadRequest)
+			return
+		}
+
+		_, err = store.Get(r.Context(), id)
+		if errors.Is(err, ErrWebhookNotFound) {
+			http.Error(rw, "Invalid webhook id; No webhook registered by that id", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Println(err.Error())
+			http.Error(rw, "Something went wrong trying to get the webhook", http.StatusInternalServerError)
+			return
+		}
+
+		records, total, err := store.ListDeliveries(r.Context(), id, offset, limit)
+		if err != nil {
+			log.Println(err.Error())
+			http.Error(rw, "Something went wrong trying to get the deliveries", http.StatusInternalServerError)
+			return
+		}
+
+		_ = json.NewEncoder(rw).Encode(&deliveriesPage{total, offset, limit, records})
+	}
+}
@@ -0,0 +1,55 @@
+package notifications
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDeliveryLog tests that the delivery history is paginated newest first, capped, and removed with the webhook,
+// for each of the stores that can run without external services.
+func TestDeliveryLog(t *testing.T) {
+	bolt, err := NewBoltStore(filepath.Join(t.TempDir(), "webhooks.db"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer bolt.Close()
+
+	for name, store := range map[string]WebhookStore{"memory": NewMemoryStore(), "bolt": bolt} {
+		ctx := context.Background()
+		id, _ := store.Create(ctx, &Webhook{URL: "http://localhost", Timeout: 60, Field: FieldConfirmed, Country: "Norway"})
+
+		start := time.Now()
+		for i := 1; i <= DeliveryHistoryLimit+5; i++ {
+			err := store.AddDelivery(ctx, &DeliveryRecord{WebhookID: id, Time: start.Add(time.Duration(i) * time.Second), Attempt: i})
+			assert.NoError(t, err, name)
+		}
+
+		records, total, err := store.ListDeliveries(ctx, id, 0, 3)
+		assert.NoError(t, err, name)
+		assert.Equal(t, DeliveryHistoryLimit, total, "%s should only keep the most recent deliveries", name)
+		if assert.Len(t, records, 3, name) {
+			assert.Equal(t, DeliveryHistoryLimit+5, records[0].Attempt, "%s should list the newest delivery first", name)
+			assert.Equal(t, DeliveryHistoryLimit+3, records[2].Attempt, name)
+		}
+
+		records, _, _ = store.ListDeliveries(ctx, id, DeliveryHistoryLimit-1, 3)
+		if assert.Len(t, records, 1, "%s should return a partial last page", name) {
+			assert.Equal(t, 6, records[0].Attempt, "%s should discard the oldest deliveries", name)
+		}
+
+		assert.NoError(t, store.Delete(ctx, id), name)
+		records, total, err = store.ListDeliveries(ctx, id, 0, 3)
+		assert.NoError(t, err, name)
+		assert.Empty(t, records, "%s should delete the history along with the webhook", name)
+		assert.Zero(t, total, name)
+
+		// A delivery that was in flight when the webhook was deleted should not bring the history back
+		assert.NoError(t, store.AddDelivery(ctx, &DeliveryRecord{WebhookID: id, Time: time.Now(), Attempt: 1}), name)
+		_, total, _ = store.ListDeliveries(ctx, id, 0, 3)
+		assert.Zero(t, total, "%s should not record deliveries to deleted webhooks", name)
+	}
+}
@@ -0,0 +1,355 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DispatcherConfig limits how deliveries are made.
+type DispatcherConfig struct {
+	// Workers is the maximum number of deliveries in flight at once.
+	Workers int
+	// PerHost is the maximum number of deliveries in flight to any one host at once.
+	PerHost int
+	// Timeout is how long to wait for a receiver to reply before giving up on a delivery.
+	Timeout time.Duration
+	// QueueSize is the maximum number of deliveries waiting to be sent. Deliveries beyond that are dropped.
+	QueueSize int
+	// Retry is how failed deliveries are retried, before ending up in the dead letters.
+	Retry RetryPolicy
+	// Egress limits where deliveries can be sent. Nil allows anything.
+	Egress *EgressPolicy
+}
+
+// DefaultDispatcherConfig is the configuration used unless configured otherwise.
+var DefaultDispatcherConfig = DispatcherConfig{
+	Workers:   16,
+	PerHost:   2,
+	Timeout:   10 * time.Second,
+	QueueSize: 4096,
+	Retry: RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   time.Second,
+		MaxDelay:    5 * time.Minute,
+	},
+	Egress: &DefaultEgressPolicy,
+}
+
+// Delivery is some data to be posted to a webhook.
+type Delivery struct {
+	Webhook Webhook
+	// Snapshots is the data to deliver. Webhooks subscribed to several countries or fields get all of it combined,
+	// others get the data of their single country and field.
+	Snapshots []Snapshot
+	// Reason is why the delivery is made, one of the Reason constants.
+	Reason string
+	// Event identifies what is delivered, across retries and replays.
+	Event Event
+	// Attempts is the number of times the delivery has been attempted so far.
+	Attempts int
+}
+
+// host returns the host the delivery is sent to, which is what the per host limit applies to.
+func (d *Delivery) host() string {
+	u, err := url.Parse(d.Webhook.URL)
+	if err != nil {
+		return d.Webhook.URL
+	}
+	return u.Host
+}
+
+// Dispatcher delivers data to webhooks using a bounded pool of workers.
+// Deliveries are queued per host, and served round robin across hosts, so that one slow receiver
+// can only ever occupy PerHost workers, and never holds up deliveries to anyone else.
+// A failed delivery is retried according to the RetryPolicy, and does not affect any other delivery.
+type Dispatcher struct {
+	store  WebhookStore
+	client *http.Client
+	config DispatcherConfig
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// pending deliveries, queued per host.
+	pending map[string][]Delivery
+	// hosts with pending deliveries, in the order they will be served.
+	hosts []string
+	// inFlight is the number of deliveries currently being sent to each host.
+	inFlight map[string]int
+	queued   int
+	stopped  bool
+}
+
+// NewDispatcher creates a dispatcher for webhooks in store.
+func NewDispatcher(store WebhookStore, config DispatcherConfig) *Dispatcher {
+	d := &Dispatcher{
+		store:    store,
+		client:   config.Egress.Client(config.Timeout),
+		config:   config,
+		pending:  make(map[string][]Delivery),
+		inFlight: make(map[string]int),
+	}
+	d.cond = sync.NewCond(&d.mu)
+
+	return d
+}
+
+// body returns the data posted to the webhook.
+func (d *Delivery) body() interface{} {
+	if d.Webhook.multi() || len(d.Snapshots) != 1 {
+		return combine(d.Snapshots)
+	}
+	return d.Snapshots[0].Body()
+}
+
+// payload encodes what is posted to the webhook in the webhook's format, or renders it from the webhook's template,
+// and returns it along with its content type.
+func (d *Delivery) payload() ([]byte, string, error) {
+	if d.Webhook.Template != "" {
+		tmpl, err := parseTemplate(d.Webhook.Template)
+		if err != nil {
+			return nil, "", err
+		}
+		rendered, err := render(tmpl, d.templateData())
+		return rendered, d.Webhook.templateContentType(), err
+	}
+
+	payload := new(bytes.Buffer)
+	if d.Webhook.Format == FormatCloudEvents {
+		_ = json.NewEncoder(payload).Encode(d.cloudEvent())
+		return payload.Bytes(), CloudEventsContentType, nil
+	}
+
+	_ = json.NewEncoder(payload).Encode(d.body())
+	return payload.Bytes(), "application/json", nil
+}
+
+// Dispatch queues data to be delivered to a webhook for the given reason, and returns immediately.
+func (d *Dispatcher) Dispatch(webhook *Webhook, snapshot *Snapshot, reason string) {
+	d.DispatchCombined(webhook, []Snapshot{*snapshot}, reason)
+}
+
+// DispatchCombined queues the data of several countries or fields to be delivered to a webhook as one.
+func (d *Dispatcher) DispatchCombined(webhook *Webhook, snapshots []Snapshot, reason string) {
+	err := d.enqueue(Delivery{Webhook: *webhook, Snapshots: snapshots, Reason: reason, Event: newEvent(reason)})
+	if errors.Is(err, errQueueFull) {
+		log.Println("Delivery queue is full, dropping delivery to webhook:", webhook.ID)
+	}
+}
+
+// Errors returned by enqueue when a delivery could not be queued.
+var (
+	errQueueFull         = errors.New("the delivery queue is full")
+	errDispatcherStopped = errors.New("the dispatcher has stopped")
+)
+
+// enqueue adds a delivery to the queue of the host it is sent to.
+func (d *Dispatcher) enqueue(delivery Delivery) error {
+	host := delivery.host()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stopped {
+		return errDispatcherStopped
+	}
+	if d.queued >= d.config.QueueSize {
+		return errQueueFull
+	}
+
+	if len(d.pending[host]) == 0 {
+		d.hosts = append(d.hosts, host)
+	}
+	d.pending[host] = append(d.pending[host], delivery)
+	d.queued++
+
+	d.cond.Signal()
+	return nil
+}
+
+// next blocks until there is a delivery to a host that is below the per host limit, and takes it.
+// Returns false if the dispatcher was stopped.
+func (d *Dispatcher) next() (Delivery, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for {
+		if d.stopped {
+			return Delivery{}, false
+		}
+
+		for i, host := range d.hosts {
+			if d.inFlight[host] >= d.config.PerHost {
+				continue
+			}
+
+			delivery := d.pending[host][0]
+			d.pending[host] = d.pending[host][1:]
+			d.queued--
+			d.inFlight[host]++
+
+			// Move the host to the back of the line, or out of it if it has nothing more pending
+			d.hosts = append(d.hosts[:i], d.hosts[i+1:]...)
+			if len(d.pending[host]) > 0 {
+				d.hosts = append(d.hosts, host)
+			} else {
+				delete(d.pending, host)
+			}
+
+			return delivery, true
+		}
+
+		d.cond.Wait()
+	}
+}
+
+// done marks a delivery to host as no longer in flight.
+func (d *Dispatcher) done(host string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.inFlight[host]--
+	if d.inFlight[host] <= 0 {
+		delete(d.inFlight, host)
+	}
+
+	// Any waiting worker might be waiting for exactly this host
+	d.cond.Broadcast()
+}
+
+// send posts the data in a delivery to the webhook, and updates the webhook's LastTriggered.
+// The outcome of the attempt is filled out in record.
+func (d *Dispatcher) send(delivery *Delivery, record *DeliveryRecord) *deliveryError {
+	failure := d.post(delivery, record)
+	if failure != nil {
+		return failure
+	}
+
+	// Update the LastTriggered field of the webhook to now, and count the invocation towards its max invocations
+	// The delivery itself succeeded, so failing to record it is not worth a retry
+	now := time.Now()
+	_, err := d.store.Update(context.Background(), delivery.Webhook.ID, func(webhook *Webhook) error {
+		webhook.LastTriggered = now
+		webhook.Invocations++
+		if webhook.expired(now) {
+			webhook.State = StateExpired
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Failed to update LastTriggered of webhook", delivery.Webhook.ID, err.Error())
+	}
+
+	return nil
+}
+
+// post posts the data in a delivery to the webhook, and fills out the outcome in record, without touching the webhook.
+func (d *Dispatcher) post(delivery *Delivery, record *DeliveryRecord) *deliveryError {
+	// Create a post request where the body is the data associated with the Webhooks field.
+	payload, contentType, err := delivery.payload()
+	if err != nil {
+		// The template renders the same way every time, so there is no point in retrying
+		return &deliveryError{err: fmt.Errorf("failed to render template: %w", err), permanent: true}
+	}
+	hash := sha256.Sum256(payload)
+	record.PayloadHash = hex.EncodeToString(hash[:])
+
+	req, err := http.NewRequest(http.MethodPost, delivery.Webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return &deliveryError{err: err}
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	// Sign the delivery, so the receiver can tell it came from us
+	delivery.Webhook.sign(req, payload, time.Now())
+
+	// Send request
+	start := time.Now()
+	res, err := d.client.Do(req)
+	record.Latency = time.Since(start).Milliseconds()
+	if err != nil {
+		return &deliveryError{err: err}
+	}
+	res.Body.Close()
+	record.StatusCode = res.StatusCode
+
+	if !corona.StatusIs2XX(res.StatusCode) {
+		return &deliveryError{
+			err:        fmt.Errorf("remote responded with non 2XX code %d", res.StatusCode),
+			statusCode: res.StatusCode,
+			retryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+		}
+	}
+
+	return nil
+}
+
+// work sends deliveries until the dispatcher is stopped.
+func (d *Dispatcher) work() {
+	for {
+		delivery, ok := d.next()
+		if !ok {
+			return
+		}
+
+		delivery.Attempts++
+		record := DeliveryRecord{
+			WebhookID: delivery.Webhook.ID,
+			Time:      time.Now(),
+			Reason:    delivery.Reason,
+			Attempt:   delivery.Attempts,
+		}
+		failure := d.send(&delivery, &record)
+		if failure != nil {
+			record.Error = failure.Error()
+		}
+
+		// Record the attempt before retrying, so the history is in the order the attempts were made
+		err := d.store.AddDelivery(context.Background(), &record)
+		if err != nil {
+			log.Println("Failed to record delivery to webhook", delivery.Webhook.ID, err.Error())
+		}
+
+		if failure != nil {
+			d.fail(&delivery, failure)
+		}
+
+		d.done(delivery.host())
+	}
+}
+
+// Run starts the workers, and waits for them to return after Stop is called.
+func (d *Dispatcher) Run(wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	workers := &sync.WaitGroup{}
+	workers.Add(d.config.Workers)
+	for i := 0; i < d.config.Workers; i++ {
+		go func() {
+			defer workers.Done()
+			d.work()
+		}()
+	}
+
+	workers.Wait()
+}
+
+// Stop makes the workers return once they are done with their current delivery.
+// Deliveries that are still queued are dropped.
+func (d *Dispatcher) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.stopped = true
+	d.cond.Broadcast()
+}
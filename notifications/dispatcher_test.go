@@ -0,0 +1,83 @@
+package notifications
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// startDispatcher runs a dispatcher for the duration of a test.
+func startDispatcher(t *testing.T, store WebhookStore, config DispatcherConfig) *Dispatcher {
+	config.Egress = nil // The receivers in the tests are on localhost
+	dispatcher := NewDispatcher(store, config)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go dispatcher.Run(wg)
+	t.Cleanup(func() {
+		dispatcher.Stop()
+		wg.Wait()
+	})
+
+	return dispatcher
+}
+
+// TestDispatcherPerHostLimit tests that a slow receiver never gets more than PerHost concurrent deliveries,
+// and does not hold up deliveries to other receivers.
+func TestDispatcherPerHostLimit(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	concurrent, maxConcurrent := 0, 0
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		concurrent++
+		if concurrent > maxConcurrent {
+			maxConcurrent = concurrent
+		}
+		mu.Unlock()
+
+		<-release
+
+		mu.Lock()
+		concurrent--
+		mu.Unlock()
+	}))
+	defer slow.Close()
+	defer close(release)
+
+	fastDelivered := make(chan struct{}, 1)
+	fast := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fastDelivered <- struct{}{}
+	}))
+	defer fast.Close()
+
+	config := DefaultDispatcherConfig
+	config.Workers = 4
+	config.PerHost = 2
+	dispatcher := startDispatcher(t, NewMemoryStore(), config)
+
+	snapshot := &Snapshot{Country: "Norway", Field: FieldConfirmed}
+	for i := 0; i < 10; i++ {
+		dispatcher.Dispatch(&Webhook{ID: "slow", URL: slow.URL}, snapshot, ReasonTimeout)
+	}
+	dispatcher.Dispatch(&Webhook{ID: "fast", URL: fast.URL}, snapshot, ReasonTimeout)
+
+	select {
+	case <-fastDelivered:
+	case <-time.After(time.Second):
+		t.Fatal("The slow receiver held up delivery to the fast receiver")
+	}
+
+	peak := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return maxConcurrent
+	}
+	assert.Eventually(t, func() bool { return peak() == 2 }, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, peak(), "The slow receiver should never get more than PerHost concurrent deliveries")
+}
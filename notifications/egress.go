@@ -0,0 +1,185 @@
+package notifications
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrEgressBlocked is returned when a request to a webhook is stopped by the EgressPolicy.
+var ErrEgressBlocked = errors.New("blocked by egress policy")
+
+// DefaultBlockedCIDRs are the networks webhooks may not connect to, unless configured otherwise:
+// loopback, private, link-local (including cloud metadata endpoints), shared, multicast and reserved addresses,
+// and the NAT64 prefixes, which would otherwise reach any of the IPv4 ones through a NAT64 gateway.
+var DefaultBlockedCIDRs = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"64:ff9b:1::/48",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+// DefaultEgressPolicy is the policy used unless configured otherwise.
+var DefaultEgressPolicy = EgressPolicy{
+	Schemes:      []string{"http", "https"},
+	Blocked:      mustParseCIDRs(DefaultBlockedCIDRs),
+	MaxRedirects: 3,
+}
+
+// EgressPolicy limits where requests to webhooks can go, so that clients can not use the server to reach
+// services that are not meant to be public, like cloud metadata endpoints or anything else on a private network.
+// It is applied both when a webhook is registered, and to every delivery.
+type EgressPolicy struct {
+	// Schemes that webhook urls, and any redirects they lead to, may use.
+	Schemes []string
+	// Blocked networks, checked against the address every connection is actually made to,
+	// after the host name is resolved, so a host name can not resolve to something harmless at registration
+	// and to a blocked address later.
+	Blocked []*net.IPNet
+	// MaxRedirects is how many redirects a request may follow, where 0 follows none.
+	MaxRedirects int
+}
+
+// ParseCIDRs parses a list of networks written in CIDR notation, like 10.0.0.0/8.
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+func mustParseCIDRs(cidrs []string) []*net.IPNet {
+	networks, err := ParseCIDRs(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return networks
+}
+
+// blocked returns true if ip is in any of the blocked networks.
+func (p *EgressPolicy) blocked(ip net.IP) bool {
+	for _, network := range p.Blocked {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkScheme returns an error wrapping ErrEgressBlocked if scheme is not allowed.
+func (p *EgressPolicy) checkScheme(scheme string) error {
+	for _, allowed := range p.Schemes {
+		if strings.EqualFold(scheme, allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("the scheme %q is %w", scheme, ErrEgressBlocked)
+}
+
+// CheckURL checks that a webhook url uses an allowed scheme, and is not an address in a blocked network.
+// Host names are checked when they are connected to, since what they resolve to can change.
+// A nil policy allows any url.
+func (p *EgressPolicy) CheckURL(raw string) error {
+	if p == nil {
+		return nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	err = p.checkScheme(u.Scheme)
+	if err != nil {
+		return err
+	}
+	if u.Hostname() == "" {
+		return errors.New("the url has no host")
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && p.blocked(ip) {
+		return fmt.Errorf("the address %s is %w", ip, ErrEgressBlocked)
+	}
+
+	return nil
+}
+
+// control is called by the dialer with the address it is about to connect to, after the host name is resolved.
+func (p *EgressPolicy) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || p.blocked(ip) {
+		return fmt.Errorf("connecting to %s is %w", host, ErrEgressBlocked)
+	}
+
+	return nil
+}
+
+// egressTransport checks the scheme of every request, including the ones redirects lead to.
+type egressTransport struct {
+	policy *EgressPolicy
+	base   http.RoundTripper
+}
+
+func (t *egressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	err := t.policy.checkScheme(req.URL.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+// Client creates a http client that gives up on requests after timeout, and enforces the policy on every request,
+// redirect and connection it makes. A nil policy gives a client without any limits other than the timeout.
+func (p *EgressPolicy) Client(timeout time.Duration) *http.Client {
+	if p == nil {
+		return &http.Client{Timeout: timeout}
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second, //nolint:gomnd // Same as the default transport
+		Control:   p.control,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// A proxy would be connected to instead of the webhook, and can reach anything
+	transport.Proxy = nil
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &egressTransport{p, transport},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > p.MaxRedirects {
+				return fmt.Errorf("following more than %d redirects is %w", p.MaxRedirects, ErrEgressBlocked)
+			}
+			return nil
+		},
+	}
+}
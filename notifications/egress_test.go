@@ -0,0 +1,83 @@
+package notifications
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCheckURL tests that urls with other schemes, or addresses in blocked networks, are rejected at registration.
+func TestCheckURL(t *testing.T) {
+	policy := &DefaultEgressPolicy
+
+	assert.NoError(t, policy.CheckURL("https://example.com/hook"))
+	assert.NoError(t, policy.CheckURL("http://93.184.216.34:8080/hook"))
+
+	for _, url := range []string{
+		"ftp://example.com",
+		"file:///etc/passwd",
+		"http://127.0.0.1:3000",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.1.2.3",
+		"http://192.168.0.1",
+		"http://[::1]/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+		"http://[64:ff9b::a9fe:a9fe]/latest/meta-data",
+		"http:///hook",
+	} {
+		assert.Error(t, policy.CheckURL(url), url)
+	}
+
+	var open *EgressPolicy
+	assert.NoError(t, open.CheckURL("http://127.0.0.1"), "A nil policy should allow anything")
+}
+
+// TestEgressClient tests that the client checks the address it actually connects to,
+// and the redirects it follows.
+func TestEgressClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/twice":
+			http.Redirect(rw, r, "/once", http.StatusFound)
+		case "/once":
+			http.Redirect(rw, r, "/", http.StatusFound)
+		case "/ftp":
+			http.Redirect(rw, r, "ftp://example.com", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	// Host names are only checked once they are resolved, so localhost is blocked when it is connected to
+	client := DefaultEgressPolicy.Client(time.Second)
+	_, err := client.Get(server.URL)
+	assert.True(t, errors.Is(err, ErrEgressBlocked), err)
+	_, err = client.Get("http://localhost:" + server.URL[len("http://127.0.0.1:"):])
+	assert.True(t, errors.Is(err, ErrEgressBlocked), err)
+
+	policy := &EgressPolicy{Schemes: []string{"http"}, MaxRedirects: 1}
+	client = policy.Client(time.Second)
+	res, err := client.Get(server.URL + "/once")
+	assert.NoError(t, err)
+	if err == nil {
+		res.Body.Close()
+	}
+	_, err = client.Get(server.URL + "/twice")
+	assert.True(t, errors.Is(err, ErrEgressBlocked), "Too many redirects should be blocked")
+	_, err = client.Get(server.URL + "/ftp")
+	assert.True(t, errors.Is(err, ErrEgressBlocked), "Redirects to other schemes should be blocked")
+}
+
+// TestEgressNotRetried tests that deliveries blocked by the egress policy are not retried, since they would be blocked again.
+func TestEgressNotRetried(t *testing.T) {
+	store := NewMemoryStore()
+	dispatcher := NewDispatcher(store, DefaultDispatcherConfig)
+
+	delivery := Delivery{Webhook: Webhook{ID: "internal", URL: "http://127.0.0.1:1"}, Snapshots: []Snapshot{{Field: FieldConfirmed}}}
+	failure := dispatcher.send(&delivery, &DeliveryRecord{})
+	assert.NotNil(t, failure)
+	assert.False(t, failure.retryable())
+}
@@ -1,246 +1,144 @@
 package notifications
 
 import (
-	"assignment-2/corona"
-	"bytes"
-	"cloud.google.com/go/firestore"
 	"context"
-	"encoding/json"
 	"log"
-	"net/http"
-	"sync"
 	"time"
 )
 
-// The error handling in this file is basically, if there is an error, print it to stdout, and move on.
-// There are very few cases where we can do much more than that.
-
-var (
-	LastConfirmed  corona.CountryResponse
-	LastStringency corona.PolicyResponse
-)
-
 // Webhook is the body of the any request involving a webhook.
 type Webhook struct {
-	URL           string    `json:"url"`
-	Timeout       int       `json:"timeout"`
-	Field         string    `json:"field"`
-	Country       string    `json:"country"`
+	// ID is assigned by the WebhookStore, and is not stored as part of the document itself.
+	ID      string `json:"id" firestore:"-"`
+	URL     string `json:"url"`
+	Timeout int    `json:"timeout,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Country string `json:"country,omitempty"`
+	// CountryCode is the alpha3 code of Country, looked up when the country is set.
+	CountryCode string `json:"country_code,omitempty"`
+	// Countries, or the countries in Continent, and Fields are used instead of Country and Field
+	// by webhooks subscribed to several countries or fields.
+	Countries []string `json:"countries,omitempty"`
+	// CountryCodes are the alpha3 codes of Countries, in the same order.
+	CountryCodes  []string  `json:"country_codes,omitempty"`
+	Continent     string    `json:"continent,omitempty"`
+	Fields        []string  `json:"fields,omitempty"`
 	Trigger       string    `json:"trigger"`
 	LastTriggered time.Time `json:"last_triggered"`
+	// Schedule is a cron expression for when to invoke the webhook, used instead of Timeout.
+	Schedule string `json:"schedule,omitempty"`
+	// Timezone the Schedule is in, UTC if empty.
+	Timezone string `json:"timezone,omitempty"`
+	// NextRun is when the webhook is next invoked. It is only filled out when the webhook is shown to the client.
+	NextRun *time.Time `json:"next_run,omitempty" firestore:"-"`
+	// Condition is only set if Trigger is TriggerOnCondition.
+	Condition *Condition `json:"condition,omitempty"`
+	// Format of the deliveries, one of the Format constants. Empty means FormatRaw.
+	Format string `json:"format,omitempty"`
+	// Template is a text/template the deliveries are rendered from, instead of being in a Format,
+	// and ContentType is what they are sent as.
+	Template    string `json:"template,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	// State is one of the State constants.
+	State string `json:"state"`
+	// Paused is set while a webhook that was paused is pending, because its url changed,
+	// so that it is paused rather than active once the new url is verified.
+	Paused bool `json:"paused,omitempty"`
+	// Expires is when the webhook expires, if ever.
+	Expires *time.Time `json:"expires,omitempty"`
+	// MaxInvocations is how many successful deliveries the webhook expires after, or 0 for no limit.
+	MaxInvocations int `json:"max_invocations,omitempty"`
+	// Invocations is the number of successful deliveries to the webhook so far.
+	Invocations int `json:"invocations"`
+	// Secret signs the deliveries to the webhook. It is only shown when it is generated, and redacted everywhere else.
+	Secret string `json:"secret,omitempty"`
+	// PreviousSecret still signs deliveries after a rotation, until PreviousSecretExpires.
+	PreviousSecret        string     `json:"previous_secret,omitempty"`
+	PreviousSecretExpires *time.Time `json:"previous_secret_expires,omitempty"`
 }
 
-// Invoke a webhook by figuring out what it's looking for and getting it.
-// Returns if the invocation resulted in new data.
-func (w *Webhook) Invoke(fs *firestore.Client, id string, useCache bool) (bool, string, error) {
-	var body interface{}
-	changed := false
-
-	// Get whatever info the webhook is interested in
-	if useCache {
-		if w.Field == FieldConfirmed {
-			body = &LastConfirmed
-		} else { // w.Field == FieldStringency
-			body = &LastStringency
-		}
-	} else {
-		if w.Field == FieldConfirmed {
-			confirmed, err := corona.GetLatestCases(w.Country)
-			if err != nil {
-				return false, "", err
-			}
-			body = &confirmed
-			if confirmed != LastConfirmed {
-				changed = true
-				LastConfirmed = confirmed
-			}
-		} else { // w.Field == FieldStringency
-			stringency, err := corona.GetLatestStringency(w.Country)
-			if err != nil {
-				return false, "", err
-			}
-			body = &stringency
-			if stringency != LastStringency {
-				changed = true
-				LastStringency = stringency
-			}
+// notify delivers fresh data to a webhook interested in its country and field, if the webhook's trigger calls for it.
+// Webhooks subscribed to several countries or fields get the latest data of all of them.
+func notify(store WebhookStore, dispatcher *Dispatcher, webhook *Webhook, snapshot *Snapshot, changed bool) {
+	switch webhook.Trigger {
+	case TriggerOnChange:
+		if !changed {
+			return
+		}
+		if webhook.multi() {
+			notifyCombined(store, dispatcher, webhook, ReasonChange)
+		} else {
+			dispatcher.Dispatch(webhook, snapshot, ReasonChange)
+		}
+	case TriggerOnCondition:
+		met, err := Evaluate(context.Background(), store, webhook, snapshot)
+		if err != nil {
+			log.Println("Failed to evaluate the condition of webhook", webhook.ID, err.Error())
+		} else if met {
+			log.Println("Condition met for webhook:", webhook.ID)
+			dispatcher.Dispatch(webhook, snapshot, ReasonCondition)
 		}
 	}
+}
 
-	// Create a post request where the body is the data associated with the Webhooks field.
-	payload := new(bytes.Buffer)
-	_ = json.NewEncoder(payload).Encode(body)
-	req, err := http.NewRequest(http.MethodPost, w.URL, payload)
-	if err != nil {
-		return false, "", err
-	}
-
-	// Send request
-	res, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return false, "", err
-	}
-	res.Body.Close()
-
-	if !corona.StatusIs2XX(res.StatusCode) {
-		return false, "", &corona.ServerError{Err: "Remote responded with non 2XX code", StatusCode: res.StatusCode}
-	}
-
-	// Update the LastTriggered field of the webhook to now
-	_, err = fs.
-		Collection(WebhookCollection).
-		Doc(id).
-		Update(context.Background(), []firestore.Update{{Path: "LastTriggered", Value: time.Now()}})
+// notifyCombined delivers the latest data of all the countries and fields a webhook is subscribed to, as one delivery.
+func notifyCombined(store WebhookStore, dispatcher *Dispatcher, webhook *Webhook, reason string) {
+	snapshots, err := gather(context.Background(), store, webhook)
 	if err != nil {
-		return false, "", err
+		log.Println("Failed to gather data for webhook", webhook.ID, err.Error())
+		return
 	}
 
-	return changed, w.Field, nil
+	dispatcher.DispatchCombined(webhook, snapshots, reason)
 }
 
-// GetNextTimeout return the next timepoint where a webhook should be invoked.
-// Returns the time of the next timeout, the id and webhook body of the next webhook to get timed out, and maybe an error.
-func GetNextTimeout(fs *firestore.Client) (time.Time, string, *Webhook, error) {
-	// Get refs to all the documents in the collection
-	docrefs, err := fs.Collection(WebhookCollection).DocumentRefs(context.Background()).GetAll()
-	if err != nil {
-		return time.Now(), "", nil, err
+// InvokeAllWithChanges dispatches the changed snapshots to all the ON_CHANGE webhooks interested in their countries
+// and fields, and to the ON_CONDITION webhooks whose condition they meet, except for the webhook with the given id.
+// Webhooks subscribed to several of the changed countries and fields get a single combined delivery.
+func InvokeAllWithChanges(store WebhookStore, dispatcher *Dispatcher, changed []*Snapshot, id string) error {
+	if len(changed) == 0 {
+		return nil
 	}
 
-	// Initialize target to after any webhook timeout point
-	target := time.Now().AddDate(100, 0, 0) // nolint:gomnd // Just some random large duration
-	// Save the latest
-	var targetWebhook *Webhook
-	var targetID string
-	for _, docref := range docrefs {
-		docsnap, err := docref.Get(context.Background())
-		if err != nil {
-			continue // Just ignore any documents that can't be fetched, either because they don't have snapshots, or some other error
-		}
-
-		// Parse the document into webhook
-		var data Webhook
-		err = docsnap.DataTo(&data)
-		if err != nil {
-			continue
-		}
-
-		// Get the timeout as a duration
-		timeoutdur := time.Duration(data.Timeout) * time.Second
-
-		// Is the timeout point of the webhook more recent than the most
-		// recently recorded
-		if current := data.LastTriggered.Add(timeoutdur); current.Before(target) {
-			target = current
-			targetWebhook = &data
-			targetID = docref.ID
-		}
-	}
-
-	return target, targetID, targetWebhook, nil
-}
-
-// InvokeAllWithField invokes all the webhooks with the specified field.
-func InvokeAllWithField(fs *firestore.Client, field, id string) error {
-	// Get refs to all the documents in the collection
-	docrefs, err := fs.Collection(WebhookCollection).DocumentRefs(context.Background()).GetAll()
+	webhooks, err := store.List(context.Background())
 	if err != nil {
 		return err
 	}
 
-	// For all the docrefs, get their document snapshot, and parse them into webhooks, and invoke them
-	for _, docref := range docrefs {
-		docsnap, err := docref.Get(context.Background())
-		if err != nil {
-			return err
-		}
-
-		// Parse the document into a webhook
-		var webhook Webhook
-		err = docsnap.DataTo(&webhook)
-		if err != nil {
-			return err
-		}
+	now := time.Now()
+	for i := range webhooks {
+		webhook := &webhooks[i]
 
 		// Skip webhooks with wrong trigger
-		if webhook.Trigger != TriggerOnChange {
+		if webhook.Trigger != TriggerOnChange && webhook.Trigger != TriggerOnCondition {
 			continue
 		}
 
-		// Skip webhooks with different fields
-		if webhook.Field != field {
+		// Skip paused and expired webhooks
+		if !webhook.Active(now) {
 			continue
 		}
 
 		// Skip the webhook that caused the refresh
-		if docref.ID == id {
+		if webhook.ID == id {
 			continue
 		}
 
-		// Invoke the webhook, we can ignore any changes that results from this
-		_, _, err = webhook.Invoke(fs, docref.ID, true)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-// Loop over webhooks and invoke them if the should be invoked.
-func InvokeLoop(fs *firestore.Client, registerChan <-chan string, wg *sync.WaitGroup) {
-	timeoutChan := make(chan string)
-
-	for {
-		next, id, webhook, err := GetNextTimeout(fs)
-		if err != nil {
-			log.Println(err.Error())
-			continue
-		}
-
-		// Has the timeout already expired?
-		if next.Before(time.Now()) {
-			changed, field, err := webhook.Invoke(fs, id, false)
-			if err != nil {
-				log.Println(err.Error())
-			}
-			if changed {
-				err = InvokeAllWithField(fs, field, id)
-				if err != nil {
-					log.Println(err.Error())
-				}
+		for _, snapshot := range changed {
+			// Skip snapshots of other countries or fields
+			if !webhook.subscribedTo(SnapshotKey(snapshot.Country, snapshot.Field)) {
+				continue
 			}
-			continue
-		}
-
-		// Sleep until next timeout
-		go func() {
-			time.Sleep(time.Until(next))
-			timeoutChan <- "timeout"
-		}()
 
-		// Either we sleep until the next timeout expires,
-		// or we run all the logic again if a new webhook gets registered
-		select {
-		case <-timeoutChan:
-			log.Println("Timeout reached, invoking webhook:", id)
-		case <-registerChan:
-			log.Println("New webhook registered")
-			continue
-		}
+			// Deliver the data we already have, no need to fetch it again
+			notify(store, dispatcher, webhook, snapshot, true)
 
-		// Now invoke the webhook, since it has timed out by now
-		changed, field, err := webhook.Invoke(fs, id, false)
-		if err != nil {
-			log.Println(err.Error())
-		}
-		if changed {
-			err = InvokeAllWithField(fs, field, id)
-			if err != nil {
-				log.Println(err.Error())
+			// The combined delivery already has the data of every other change
+			if webhook.multi() {
+				break
 			}
 		}
 	}
 
-	wg.Done()
+	return nil
 }
@@ -0,0 +1,129 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultPollInterval is how often the poller checks for changes, unless configured otherwise.
+const DefaultPollInterval = 15 * time.Minute
+
+// Poller periodically refreshes the data of every country and field that has ON_CHANGE or ON_CONDITION webhooks
+// subscribed to it, or is followed by a subscriber of the broker, and delivers the fresh data to the subscribers of the pairs that changed,
+// and to the subscribers whose condition it meets.
+// This way ON_CHANGE webhooks fire when the data changes, not only when some other webhook happens to time out.
+type Poller struct {
+	store      WebhookStore
+	providers  corona.Providers
+	dispatcher *Dispatcher
+	broker     *Broker
+	interval   time.Duration
+}
+
+// NewPoller creates a poller that checks for changes every interval, or DefaultPollInterval if it is not positive,
+// hands deliveries off to dispatcher, and the changes to broker, which may be nil.
+func NewPoller(store WebhookStore, providers corona.Providers, dispatcher *Dispatcher, broker *Broker, interval time.Duration) *Poller {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	return &Poller{store, providers, dispatcher, broker, interval}
+}
+
+// subscription is a country and field, and the webhooks subscribed to it.
+type subscription struct {
+	pair
+	webhooks []*Webhook
+}
+
+// subscribers groups the active ON_CHANGE and ON_CONDITION webhooks by the countries and fields they are interested in.
+// Webhooks subscribed to several countries or fields are part of several groups.
+// The countries and fields followed through the broker get a group as well, even without any webhooks.
+func subscribers(webhooks []Webhook, followed []pair) map[string]*subscription {
+	subscriptions := make(map[string]*subscription)
+	add := func(p pair) *subscription {
+		sub, ok := subscriptions[p.key()]
+		if !ok {
+			sub = &subscription{pair: p}
+			subscriptions[p.key()] = sub
+		}
+		// Older webhooks might not have the code of the country
+		if sub.code == "" {
+			sub.code = p.code
+		}
+		return sub
+	}
+
+	for _, p := range followed {
+		add(p)
+	}
+
+	now := time.Now()
+	for i := range webhooks {
+		trigger := webhooks[i].Trigger
+		if (trigger != TriggerOnChange && trigger != TriggerOnCondition) || !webhooks[i].Active(now) {
+			continue
+		}
+
+		for _, p := range webhooks[i].pairs() {
+			sub := add(p)
+			sub.webhooks = append(sub.webhooks, &webhooks[i])
+		}
+	}
+
+	return subscriptions
+}
+
+// Poll refreshes every subscribed country and field once, and delivers any changes.
+// A failure to refresh one pair is logged, and does not stop the others.
+// Webhooks subscribed to several countries or fields get a single delivery, however many of them changed.
+func (p *Poller) Poll() {
+	webhooks, err := p.store.List(context.Background())
+	if err != nil {
+		log.Println("Poller failed to list webhooks:", err.Error())
+		return
+	}
+
+	combined := make(map[string]*Webhook)
+	for _, sub := range subscribers(webhooks, p.broker.Follows()) {
+		snapshot, changed, err := Refresh(context.Background(), p.store, p.providers, sub.country, sub.code, sub.field)
+		if err != nil {
+			log.Println("Poller failed to refresh", sub.country, sub.field, err.Error())
+			continue
+		}
+		if changed {
+			log.Println("Change detected in", snapshot.Field, "for", snapshot.Country)
+			p.broker.Publish(snapshot)
+		}
+
+		// Conditions are evaluated even without a change, since they might be new
+		for _, webhook := range sub.webhooks {
+			if webhook.multi() {
+				if changed {
+					combined[webhook.ID] = webhook
+				}
+			} else {
+				notify(p.store, p.dispatcher, webhook, snapshot, changed)
+			}
+		}
+	}
+
+	// Everything has been refreshed by now, so the combined deliveries have the latest data of every pair
+	for _, webhook := range combined {
+		notifyCombined(p.store, p.dispatcher, webhook, ReasonChange)
+	}
+}
+
+// Run polls every interval, forever.
+func (p *Poller) Run(wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		p.Poll()
+	}
+}
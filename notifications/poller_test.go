@@ -0,0 +1,66 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPollerDeliversToSubscribers tests that the poller only delivers to the ON_CHANGE subscribers of a pair that changed.
+func TestPollerDeliversToSubscribers(t *testing.T) {
+	ctx := context.Background()
+
+	// Record which path each delivery was sent to
+	var mu sync.Mutex
+	received := make(map[string]int)
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received[r.URL.Path]++
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	store := NewMemoryStore()
+	webhooks := []Webhook{
+		{URL: receiver.URL + "/norway", Country: "Norway", Field: FieldConfirmed, Trigger: TriggerOnChange},
+		{URL: receiver.URL + "/sweden", Country: "Sweden", Field: FieldConfirmed, Trigger: TriggerOnChange},
+		{URL: receiver.URL + "/timeout", Country: "Norway", Field: FieldConfirmed, Trigger: TriggerOnTimeout, Timeout: 3600},
+	}
+	for i := range webhooks {
+		webhooks[i].LastTriggered = time.Now()
+		_, err := store.Create(ctx, &webhooks[i])
+		assert.NoError(t, err)
+	}
+
+	cases := countingCases{"Norway": 100, "Sweden": 200}
+	dispatcher := startDispatcher(t, store, DefaultDispatcherConfig)
+	poller := NewPoller(store, corona.Providers{Cases: cases}, dispatcher, nil, time.Minute)
+
+	deliveries := func() map[string]int {
+		mu.Lock()
+		defer mu.Unlock()
+		copied := make(map[string]int)
+		for path, n := range received {
+			copied[path] = n
+		}
+		return copied
+	}
+
+	// First poll only establishes a baseline
+	poller.Poll()
+
+	cases["Norway"] = 150
+	poller.Poll()
+	assert.Eventually(t, func() bool { return deliveries()["/norway"] == 1 }, time.Second, 10*time.Millisecond)
+
+	// Nothing changed since the last poll
+	poller.Poll()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, map[string]int{"/norway": 1}, deliveries(), "Only subscribers of the changed pair should be notified")
+}
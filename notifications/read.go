@@ -1,23 +1,21 @@
 package notifications
 
 import (
-	"cloud.google.com/go/firestore"
 	"encoding/json"
+	"errors"
 	"github.com/go-chi/chi"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 	"log"
 	"net/http"
+	"time"
 )
 
 // NewReadHandler creates a HttpHandler that reads one webhook from the database and returns it.
-func NewReadHandler(fs *firestore.Client) http.HandlerFunc {
+func NewReadHandler(store WebhookStore) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		id := chi.URLParam(r, "id")
 
-		docref := fs.Collection(WebhookCollection).Doc(id)
-		docsnap, err := docref.Get(r.Context())
-		if status.Code(err) == codes.NotFound {
+		data, err := store.Get(r.Context(), id)
+		if errors.Is(err, ErrWebhookNotFound) {
 			http.Error(rw, "Invalid webhook id; No webhook registered by that id", http.StatusBadRequest)
 			return
 		} else if err != nil {
@@ -26,55 +24,25 @@ func NewReadHandler(fs *firestore.Client) http.HandlerFunc {
 			return
 		}
 
-		var data Webhook
-		err = docsnap.DataTo(&data)
-		if err != nil {
-			log.Println(err.Error())
-			http.Error(rw, "Something went wrong trying to read the webhook", http.StatusInternalServerError)
-			return
-		}
-
-		_ = json.NewEncoder(rw).Encode(&data)
+		data.display(time.Now())
+		_ = json.NewEncoder(rw).Encode(data)
 	}
 }
 
 // NewReadAllHandler creates a HttpHandler that reads all the webhooks from the database and returns them.
-func NewReadAllHandler(fs *firestore.Client) http.HandlerFunc {
+func NewReadAllHandler(store WebhookStore) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		// Get refs to all the documents in the collection
-		docrefs, err := fs.Collection(WebhookCollection).DocumentRefs(r.Context()).GetAll()
+		body, err := store.List(r.Context())
 		if err != nil {
 			log.Println(err.Error())
 			http.Error(rw, "Something went wrong trying to get the webhooks", http.StatusInternalServerError)
 			return
 		}
 
-		// For all the docrefs, get their document snapshot,
-		// and read the data into a struct, then append that struct to `body`
-		// NOTE: There might be docrefs for which there is no document, therefore we can not preallocate the body slice.
-		body := make([]Webhook, 0)
-		for _, docref := range docrefs {
-			docsnap, err := docref.Get(r.Context())
-			if status.Code(err) == codes.NotFound {
-				http.Error(rw, "Invalid webhook id; No webhook registered by that id", http.StatusBadRequest)
-				return
-			} else if err != nil {
-				log.Println(err.Error())
-				http.Error(rw, "Something went wrong trying to get the webhook", http.StatusInternalServerError)
-				return
-			}
-
-			var data Webhook
-			err = docsnap.DataTo(&data)
-			if err != nil {
-				log.Println(err.Error())
-				http.Error(rw, "Something went wrong trying to read the webhook", http.StatusInternalServerError)
-				return
-			}
-
-			body = append(body, data)
+		now := time.Now()
+		for i := range body {
+			body[i].display(now)
 		}
-
 		_ = json.NewEncoder(rw).Encode(&body)
 	}
 }
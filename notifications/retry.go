@@ -0,0 +1,163 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// MaxRetryAfter caps how long a receiver can ask us to wait before retrying, using Retry-After.
+const MaxRetryAfter = time.Hour
+
+// RetryPolicy decides how often and how long apart failed deliveries are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts, including the first one, before a delivery is given up on.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, which is doubled for every retry after it.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between retries, unless the receiver asks for more using Retry-After,
+	// up to MaxRetryAfter.
+	MaxDelay time.Duration
+}
+
+// backoff returns how long to wait before retrying a delivery that has been attempted attempts times.
+// The delay grows exponentially, with jitter so that deliveries that failed together don't retry together.
+func (p *RetryPolicy) backoff(attempts int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	// Wait somewhere between half and all of the delay
+	half := int64(delay / 2) //nolint:gomnd // Half is half
+	if half <= 0 {
+		return delay
+	}
+	return time.Duration(half + rand.Int63n(half+1)) //nolint:gosec // Jitter does not need a secure source
+}
+
+// deliveryError describes why a delivery failed.
+type deliveryError struct {
+	err error
+	// statusCode is the status the receiver replied with, or 0 if it never replied.
+	statusCode int
+	// retryAfter is how long the receiver asked us to wait before retrying, or 0 if it did not say.
+	retryAfter time.Duration
+	// permanent is true if the delivery will fail the same way however many times it is retried.
+	permanent bool
+}
+
+func (e *deliveryError) Error() string {
+	return e.err.Error()
+}
+
+// retryable returns true if the delivery failed in a way that might go away if we try again.
+// Receivers that reject the delivery outright (most 4XX codes) will just reject it again,
+// and the egress policy will block a delivery again.
+func (e *deliveryError) retryable() bool {
+	if e.permanent || errors.Is(e.err, ErrEgressBlocked) {
+		return false
+	}
+	return e.statusCode == 0 ||
+		e.statusCode >= http.StatusInternalServerError ||
+		e.statusCode == http.StatusTooManyRequests ||
+		e.statusCode == http.StatusRequestTimeout
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a number of seconds or a http date,
+// capped at MaxRetryAfter. Returns 0 if the header is missing or invalid.
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		delay = time.Duration(seconds) * time.Second
+		// Seconds beyond the cap could overflow the duration
+		if seconds > int(MaxRetryAfter/time.Second) {
+			delay = MaxRetryAfter
+		}
+	} else if t, err := http.ParseTime(header); err == nil {
+		delay = time.Until(t)
+	}
+
+	if delay < 0 {
+		return 0
+	}
+	if delay > MaxRetryAfter {
+		return MaxRetryAfter
+	}
+	return delay
+}
+
+// fail either schedules a failed delivery to be retried, or moves it to the dead letters if it has run out of retries.
+func (d *Dispatcher) fail(delivery *Delivery, failure *deliveryError) {
+	policy := &d.config.Retry
+	if failure.retryable() && delivery.Attempts < policy.MaxAttempts {
+		delay := policy.backoff(delivery.Attempts)
+		if failure.retryAfter > delay {
+			delay = failure.retryAfter
+		}
+
+		log.Printf("Delivery to webhook %s failed (attempt %d), retrying in %s: %s",
+			delivery.Webhook.ID, delivery.Attempts, delay, failure.Error())
+		retry := *delivery
+		time.AfterFunc(delay, func() { d.retry(&retry, failure) })
+		return
+	}
+
+	log.Printf("Delivery to webhook %s failed (attempt %d), giving up: %s", delivery.Webhook.ID, delivery.Attempts, failure.Error())
+	d.deadLetter(delivery, failure.Error(), failure.statusCode)
+}
+
+// retry queues a failed delivery again, to the webhook as it is now, since it might have changed in the meantime.
+// The delivery is dropped if the webhook has been deleted, and moved to the dead letters if the webhook is no longer
+// active, or the delivery can not be queued, so that it can be replayed later.
+func (d *Dispatcher) retry(delivery *Delivery, failure *deliveryError) {
+	webhook, err := d.store.Get(context.Background(), delivery.Webhook.ID)
+	if errors.Is(err, ErrWebhookNotFound) {
+		log.Println("Dropping retry to deleted webhook:", delivery.Webhook.ID)
+		return
+	} else if err != nil {
+		log.Println("Failed to get webhook", delivery.Webhook.ID, "to retry:", err.Error())
+		d.deadLetter(delivery, failure.Error(), failure.statusCode)
+		return
+	}
+
+	if !webhook.Active(time.Now()) {
+		log.Println("Not retrying delivery to webhook", webhook.ID, "which is no longer active")
+		d.deadLetter(delivery, failure.Error(), failure.statusCode)
+		return
+	}
+
+	delivery.Webhook = *webhook
+	err = d.enqueue(*delivery)
+	if err != nil {
+		log.Println("Failed to queue retry to webhook", webhook.ID, err.Error())
+		d.deadLetter(delivery, failure.Error()+"; the retry could not be queued: "+err.Error(), failure.statusCode)
+	}
+}
+
+// deadLetter moves a delivery that failed with message and statusCode to the dead letters.
+func (d *Dispatcher) deadLetter(delivery *Delivery, message string, statusCode int) {
+	_, err := d.store.AddDeadLetter(context.Background(), &DeadLetter{
+		WebhookID:  delivery.Webhook.ID,
+		Snapshots:  delivery.Snapshots,
+		Event:      delivery.Event,
+		Attempts:   delivery.Attempts,
+		Error:      message,
+		StatusCode: statusCode,
+		Failed:     time.Now(),
+	})
+	if err != nil {
+		log.Println("Failed to store dead letter for webhook", delivery.Webhook.ID, err.Error())
+	}
+}
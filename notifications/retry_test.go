@@ -0,0 +1,177 @@
+package notifications
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+)
+
+// fastRetries is a dispatcher config that retries quickly enough for tests.
+func fastRetries() DispatcherConfig {
+	config := DefaultDispatcherConfig
+	config.Retry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	return config
+}
+
+// TestBackoff tests that the backoff grows exponentially, stays within the max delay, and is jittered.
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	for attempts, full := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 8: 10 * time.Second} {
+		delay := policy.backoff(attempts)
+		assert.True(t, delay >= full/2 && delay <= full, "Backoff after %d attempts should be between %s and %s, was %s",
+			attempts, full/2, full, delay)
+	}
+
+	assert.Equal(t, 3*time.Second, parseRetryAfter("3"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+	assert.Equal(t, MaxRetryAfter, parseRetryAfter("999999999999"), "Receivers can not put off retries forever")
+	assert.Equal(t, MaxRetryAfter, parseRetryAfter(time.Now().AddDate(1, 0, 0).UTC().Format(http.TimeFormat)))
+}
+
+// TestRetryUntilSuccess tests that a delivery failing with a 5XX is retried until it succeeds.
+func TestRetryUntilSuccess(t *testing.T) {
+	var attempts int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	store := NewMemoryStore()
+	webhook := Webhook{URL: receiver.URL}
+	webhook.ID, _ = store.Create(context.Background(), &webhook)
+	dispatcher := startDispatcher(t, store, fastRetries())
+	dispatcher.Dispatch(&webhook, &Snapshot{Field: FieldConfirmed}, ReasonTimeout)
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&attempts) == 3 }, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	letters, _ := store.ListDeadLetters(context.Background())
+	assert.Empty(t, letters, "A delivery that eventually succeeds should not end up in the dead letters")
+
+	records, total, _ := store.ListDeliveries(context.Background(), webhook.ID, 0, DeliveryHistoryLimit)
+	assert.Equal(t, 3, total, "Every attempt should be recorded")
+	if assert.Len(t, records, 3) {
+		assert.Equal(t, []int{3, 2, 1}, []int{records[0].Attempt, records[1].Attempt, records[2].Attempt}, "Newest attempt first")
+		assert.Equal(t, http.StatusOK, records[0].StatusCode)
+		assert.Empty(t, records[0].Error)
+		assert.Equal(t, http.StatusServiceUnavailable, records[1].StatusCode)
+		assert.NotEmpty(t, records[1].Error)
+		assert.Equal(t, records[0].PayloadHash, records[1].PayloadHash, "Retries deliver the same payload")
+		assert.Equal(t, ReasonTimeout, records[2].Reason)
+	}
+}
+
+// TestDeadLetter tests that deliveries end up in the dead letters when retries run out,
+// or right away if the receiver rejects them outright.
+func TestDeadLetter(t *testing.T) {
+	var attempts int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		if r.URL.Path == "/gone" {
+			rw.WriteHeader(http.StatusGone)
+			return
+		}
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	store := NewMemoryStore()
+	broken := Webhook{URL: receiver.URL + "/broken"}
+	broken.ID, _ = store.Create(context.Background(), &broken)
+	gone := Webhook{URL: receiver.URL + "/gone"}
+	gone.ID, _ = store.Create(context.Background(), &gone)
+
+	dispatcher := startDispatcher(t, store, fastRetries())
+	snapshot := &Snapshot{Country: "Norway", Field: FieldConfirmed}
+	dispatcher.Dispatch(&broken, snapshot, ReasonTimeout)
+	dispatcher.Dispatch(&gone, snapshot, ReasonTimeout)
+
+	var letters []DeadLetter
+	assert.Eventually(t, func() bool {
+		letters, _ = store.ListDeadLetters(context.Background())
+		return len(letters) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	attemptsByWebhook := make(map[string]int)
+	for _, letter := range letters {
+		attemptsByWebhook[letter.WebhookID] = letter.Attempts
+	}
+	assert.Equal(t, map[string]int{broken.ID: 3, gone.ID: 1}, attemptsByWebhook)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&attempts))
+}
+
+// TestRetryRereadsWebhook tests that retries are dropped if the webhook has been deleted in the meantime,
+// and moved to the dead letters if it has been paused, or the retry can not be queued.
+func TestRetryRereadsWebhook(t *testing.T) {
+	var attempts int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	ctx := context.Background()
+	store := NewMemoryStore()
+	paused := Webhook{URL: receiver.URL, State: StatePaused}
+	paused.ID, _ = store.Create(ctx, &paused)
+
+	dispatcher := startDispatcher(t, store, fastRetries())
+	snapshot := &Snapshot{Country: "Norway", Field: FieldConfirmed}
+	dispatcher.Dispatch(&Webhook{ID: "deleted", URL: receiver.URL}, snapshot, ReasonTimeout)
+	dispatcher.Dispatch(&paused, snapshot, ReasonTimeout)
+
+	var letters []DeadLetter
+	assert.Eventually(t, func() bool {
+		letters, _ = store.ListDeadLetters(ctx)
+		return len(letters) == 1
+	}, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, paused.ID, letters[0].WebhookID)
+	assert.Equal(t, 1, letters[0].Attempts)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts), "Neither webhook should be retried")
+
+	// A retry that can not be queued is not lost either
+	active := Webhook{URL: receiver.URL, State: StateActive}
+	active.ID, _ = store.Create(ctx, &active)
+	stopped := NewDispatcher(store, fastRetries())
+	stopped.Stop()
+	stopped.retry(&Delivery{Webhook: active, Snapshots: []Snapshot{*snapshot}, Attempts: 1}, &deliveryError{err: errQueueFull})
+	letters, _ = store.ListDeadLetters(ctx)
+	assert.Len(t, letters, 2)
+}
+
+// TestReplay tests that a dead letter is only removed once its replay has been queued.
+func TestReplay(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	webhook := Webhook{URL: "http://localhost", State: StateActive}
+	webhook.ID, _ = store.Create(ctx, &webhook)
+	id, _ := store.AddDeadLetter(ctx, &DeadLetter{WebhookID: webhook.ID, Snapshots: []Snapshot{{Country: "Norway"}}})
+
+	replay := func(dispatcher *Dispatcher) int {
+		r := chi.NewRouter()
+		r.Post("/{id}", NewReplayHandler(store, dispatcher))
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/"+id, nil))
+		return rw.Code
+	}
+
+	stopped := NewDispatcher(store, fastRetries())
+	stopped.Stop()
+	assert.Equal(t, http.StatusServiceUnavailable, replay(stopped))
+	_, err := store.GetDeadLetter(ctx, id)
+	assert.NoError(t, err, "A replay that could not be queued should keep the dead letter")
+
+	assert.Equal(t, http.StatusAccepted, replay(NewDispatcher(store, fastRetries())))
+	_, err = store.GetDeadLetter(ctx, id)
+	assert.ErrorIs(t, err, ErrDeadLetterNotFound)
+}
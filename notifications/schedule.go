@@ -0,0 +1,102 @@
+package notifications
+
+import (
+	"log"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// invalidScheduleDelay is how long to wait before trying again, if a schedule somehow can not be parsed at runtime.
+const invalidScheduleDelay = time.Hour
+
+// cronSchedule parses the cron expression of the webhook, in the webhook's timezone.
+// Without a timezone the expression is in UTC, so it does not depend on where the server runs.
+func (w *Webhook) cronSchedule() (cron.Schedule, error) {
+	timezone := w.Timezone
+	if timezone == "" {
+		timezone = "UTC"
+	}
+
+	return cron.ParseStandard("CRON_TZ=" + timezone + " " + w.Schedule)
+}
+
+// validateSchedule checks that a webhook has either a valid cron schedule, or a positive timeout, but not both.
+func validateSchedule(webhook *Webhook) error {
+	if webhook.Schedule == "" {
+		if webhook.Timezone != "" {
+			return validationError("A timezone can only be used together with a schedule")
+		}
+		if webhook.Timeout <= 0 {
+			return validationError("The timeout must be a positive number of seconds")
+		}
+		return nil
+	}
+
+	if webhook.Timeout != 0 {
+		return validationError("A webhook can have either a timeout or a schedule, not both")
+	}
+	if webhook.Timezone != "" {
+		_, err := time.LoadLocation(webhook.Timezone)
+		if err != nil {
+			return validationError("The timezone supplied does not exist")
+		}
+	}
+
+	schedule, err := webhook.cronSchedule()
+	if err != nil {
+		return validationError("The schedule must be a cron expression with 5 fields, or a descriptor like @daily; " + err.Error())
+	}
+	if schedule.Next(time.Now()).IsZero() {
+		return validationError("The schedule never fires")
+	}
+
+	return nil
+}
+
+// nextTimeout returns when the webhook should next be invoked, after it was last invoked at last.
+// Webhooks with a schedule are invoked at the next time it matches, others once their timeout has passed.
+// Timeouts shorter than a second are treated as a second, so that a bad webhook can't keep the scheduler spinning.
+func nextTimeout(webhook *Webhook, last time.Time) time.Time {
+	if webhook.Schedule != "" {
+		schedule, err := webhook.cronSchedule()
+		if err != nil {
+			log.Println("Invalid schedule for webhook", webhook.ID, err.Error())
+			return last.Add(invalidScheduleDelay)
+		}
+
+		next := schedule.Next(last)
+		if next.IsZero() {
+			return last.Add(invalidScheduleDelay)
+		}
+		return next
+	}
+
+	timeout := time.Duration(webhook.Timeout) * time.Second
+	if timeout < time.Second {
+		timeout = time.Second
+	}
+	return last.Add(timeout)
+}
+
+// setNextRun fills out NextRun with when the webhook will next be invoked by the scheduler, or nil if it will not be.
+func (w *Webhook) setNextRun(now time.Time) {
+	w.NextRun = nil
+	if !w.Active(now) {
+		return
+	}
+
+	next := nextTimeout(w, w.LastTriggered)
+	if next.Before(now) {
+		next = now // Overdue, it is invoked as soon as the scheduler gets to it
+	}
+	w.NextRun = &next
+}
+
+// display prepares a webhook to be shown to the client: the secrets are removed,
+// and the state and next run are brought up to date.
+func (w *Webhook) display(now time.Time) {
+	w.redact()
+	w.refreshState(now)
+	w.setNextRun(now)
+}
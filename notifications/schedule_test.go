@@ -0,0 +1,57 @@
+package notifications
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNextTimeoutSchedule tests that webhooks with a schedule are invoked when it next matches, in their timezone.
+func TestNextTimeoutSchedule(t *testing.T) {
+	oslo, err := time.LoadLocation("Europe/Oslo")
+	if err != nil {
+		t.Skip("No timezone data available:", err.Error())
+	}
+
+	weekdays := Webhook{Schedule: "0 8 * * 1-5", Timezone: "Europe/Oslo"}
+	friday := time.Date(2021, 3, 5, 9, 0, 0, 0, oslo)
+	monday := time.Date(2021, 3, 8, 8, 0, 0, 0, oslo)
+	assert.True(t, nextTimeout(&weekdays, friday).Equal(monday), "Should skip the weekend")
+	assert.True(t, nextTimeout(&weekdays, monday.Add(-time.Minute)).Equal(monday))
+
+	utc := Webhook{Schedule: "@daily"}
+	assert.True(t, nextTimeout(&utc, friday).Equal(time.Date(2021, 3, 6, 0, 0, 0, 0, time.UTC)), "Should default to UTC")
+
+	timeout := Webhook{Timeout: 60}
+	assert.True(t, nextTimeout(&timeout, friday).Equal(friday.Add(time.Minute)))
+}
+
+// TestValidateSchedule tests that a webhook needs exactly one of a timeout or a valid schedule.
+func TestValidateSchedule(t *testing.T) {
+	assert.NoError(t, validateSchedule(&Webhook{Timeout: 60}))
+	assert.NoError(t, validateSchedule(&Webhook{Schedule: "*/15 * * * *"}))
+	assert.NoError(t, validateSchedule(&Webhook{Schedule: "@hourly", Timezone: "UTC"}))
+
+	assert.Error(t, validateSchedule(&Webhook{}))
+	assert.Error(t, validateSchedule(&Webhook{Timeout: 60, Schedule: "@hourly"}))
+	assert.Error(t, validateSchedule(&Webhook{Timeout: 60, Timezone: "UTC"}))
+	assert.Error(t, validateSchedule(&Webhook{Schedule: "every morning"}))
+	assert.Error(t, validateSchedule(&Webhook{Schedule: "@hourly", Timezone: "Mars/Olympus_Mons"}))
+	assert.Error(t, validateSchedule(&Webhook{Schedule: "0 0 30 2 *"}), "February 30th never comes")
+}
+
+// TestSetNextRun tests that the next run shown to the client is never in the past, and missing for inactive webhooks.
+func TestSetNextRun(t *testing.T) {
+	now := time.Now()
+
+	webhook := Webhook{Timeout: 60, LastTriggered: now.Add(-time.Hour)}
+	webhook.setNextRun(now)
+	if assert.NotNil(t, webhook.NextRun) {
+		assert.True(t, webhook.NextRun.Equal(now), "An overdue webhook runs right away")
+	}
+
+	webhook = Webhook{Timeout: 60, LastTriggered: now, State: StatePaused}
+	webhook.setNextRun(now)
+	assert.Nil(t, webhook.NextRun)
+}
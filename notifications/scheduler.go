@@ -0,0 +1,321 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"container/heap"
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// schedulerBacklog is how many schedule events can be queued up while the scheduler is busy with the queue.
+const schedulerBacklog int = 64
+
+// timeout is a point in time where a webhook should be invoked.
+type timeout struct {
+	webhook Webhook
+	at      time.Time
+	// index of the timeout in the queue, maintained by the heap interface.
+	index int
+}
+
+// timeoutQueue is a min-heap of timeouts, ordered by when they expire.
+type timeoutQueue []*timeout
+
+func (q timeoutQueue) Len() int           { return len(q) }
+func (q timeoutQueue) Less(i, j int) bool { return q[i].at.Before(q[j].at) }
+
+func (q timeoutQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *timeoutQueue) Push(x interface{}) {
+	t := x.(*timeout)
+	t.index = len(*q)
+	*q = append(*q, t)
+}
+
+func (q *timeoutQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil // Don't keep a reference to the popped timeout around
+	*q = old[:n-1]
+	return t
+}
+
+// scheduleEvent tells the scheduler that a webhook was registered, changed or deleted.
+type scheduleEvent struct {
+	id string
+	// webhook is nil if the webhook was deleted.
+	webhook *Webhook
+}
+
+// Scheduler invokes webhooks when their timeout expires.
+// The timeouts are kept in a min-heap that is kept in sync with the store through Schedule and Unschedule,
+// so the store is only read once at startup, and a single timer is reset to the earliest timeout.
+type Scheduler struct {
+	store      WebhookStore
+	providers  corona.Providers
+	dispatcher *Dispatcher
+	broker     *Broker
+	events     chan scheduleEvent
+	quit       chan struct{}
+
+	// The queue and index are only touched by the Run goroutine.
+	queue    timeoutQueue
+	timeouts map[string]*timeout
+
+	// invoking are the ids of the webhooks currently being invoked, off the Run goroutine.
+	mu       sync.Mutex
+	invoking map[string]struct{}
+}
+
+// NewScheduler creates a scheduler for the webhooks in store, that hands deliveries off to dispatcher,
+// and the changes it detects to broker, which may be nil.
+func NewScheduler(store WebhookStore, providers corona.Providers, dispatcher *Dispatcher, broker *Broker) *Scheduler {
+	return &Scheduler{
+		store:      store,
+		providers:  providers,
+		dispatcher: dispatcher,
+		broker:     broker,
+		events:     make(chan scheduleEvent, schedulerBacklog),
+		quit:       make(chan struct{}),
+		timeouts:   make(map[string]*timeout),
+		invoking:   make(map[string]struct{}),
+	}
+}
+
+// Schedule tells the scheduler that a webhook was registered or changed.
+func (s *Scheduler) Schedule(webhook *Webhook) {
+	data := *webhook
+	s.events <- scheduleEvent{data.ID, &data}
+}
+
+// Unschedule tells the scheduler that a webhook was deleted.
+func (s *Scheduler) Unschedule(id string) {
+	s.events <- scheduleEvent{id, nil}
+}
+
+// Stop makes Run return.
+func (s *Scheduler) Stop() {
+	close(s.quit)
+}
+
+// set adds a webhook to the queue, or moves it if it is already there.
+func (s *Scheduler) set(webhook *Webhook, at time.Time) {
+	if t, ok := s.timeouts[webhook.ID]; ok {
+		t.webhook = *webhook
+		t.at = at
+		heap.Fix(&s.queue, t.index)
+		return
+	}
+
+	t := &timeout{webhook: *webhook, at: at}
+	heap.Push(&s.queue, t)
+	s.timeouts[webhook.ID] = t
+}
+
+// remove removes a webhook from the queue, if it is there.
+func (s *Scheduler) remove(id string) {
+	if t, ok := s.timeouts[id]; ok {
+		heap.Remove(&s.queue, t.index)
+		delete(s.timeouts, id)
+	}
+}
+
+// handle applies a schedule event to the queue.
+func (s *Scheduler) handle(event scheduleEvent) {
+	if event.webhook == nil || !event.webhook.Active(time.Now()) {
+		log.Println("Unscheduling webhook:", event.id)
+		s.remove(event.id)
+		return
+	}
+
+	log.Println("Scheduling webhook:", event.id)
+	s.set(event.webhook, nextTimeout(event.webhook, event.webhook.LastTriggered))
+}
+
+// resetTimer resets the timer to fire at the earliest timeout in the queue.
+func (s *Scheduler) resetTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		// Drain the channel in case the timer fired, but we never received from it
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+
+	if len(s.queue) == 0 {
+		return // Nothing to wait for, the timer stays stopped until something is scheduled
+	}
+	timer.Reset(time.Until(s.queue[0].at))
+}
+
+// invoke fetches the data a webhook is interested in, and dispatches it to the webhook.
+// If the data changed, it is dispatched to the ON_CHANGE webhooks interested in it as well.
+func (s *Scheduler) invoke(webhook *Webhook) {
+	if webhook.multi() {
+		s.invokeCombined(webhook)
+		return
+	}
+
+	snapshot, changed, err := Refresh(context.Background(), s.store, s.providers, webhook.Country, webhook.CountryCode, webhook.Field)
+	if err != nil {
+		log.Println("Failed to refresh data for webhook", webhook.ID, err.Error())
+		return
+	}
+
+	// ON_CONDITION webhooks only use their timeout to decide how often to check their condition
+	if webhook.Trigger == TriggerOnCondition {
+		notify(s.store, s.dispatcher, webhook, snapshot, changed)
+	} else {
+		s.dispatcher.Dispatch(webhook, snapshot, ReasonTimeout)
+	}
+
+	if changed {
+		s.broker.Publish(snapshot)
+		err = InvokeAllWithChanges(s.store, s.dispatcher, []*Snapshot{snapshot}, webhook.ID)
+		if err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+// invokeCombined refreshes all the countries and fields a webhook is subscribed to, and delivers them as one.
+// Pairs that fail to refresh are left out. Once everything is refreshed, the other webhooks of the pairs that changed
+// are notified as usual, so that those subscribed to several of them get a single delivery with all the changes.
+func (s *Scheduler) invokeCombined(webhook *Webhook) {
+	snapshots := make([]Snapshot, 0)
+	changes := make([]*Snapshot, 0)
+	for _, p := range webhook.pairs() {
+		snapshot, changed, err := Refresh(context.Background(), s.store, s.providers, p.country, p.code, p.field)
+		if err != nil {
+			log.Println("Failed to refresh", p.country, p.field, "for webhook", webhook.ID, err.Error())
+			continue
+		}
+		snapshots = append(snapshots, *snapshot)
+
+		if changed {
+			s.broker.Publish(snapshot)
+			changes = append(changes, snapshot)
+		}
+	}
+
+	err := InvokeAllWithChanges(s.store, s.dispatcher, changes, webhook.ID)
+	if err != nil {
+		log.Println(err.Error())
+	}
+
+	if len(snapshots) == 0 {
+		return
+	}
+	s.dispatcher.DispatchCombined(webhook, snapshots, ReasonTimeout)
+}
+
+// current returns the webhook as it is in the store, since deliveries may have used up its invocations
+// since it was scheduled. Returns false if the webhook should no longer be invoked.
+func (s *Scheduler) current(webhook *Webhook, now time.Time) (*Webhook, bool) {
+	stored, err := s.store.Get(context.Background(), webhook.ID)
+	if errors.Is(err, ErrWebhookNotFound) {
+		return nil, false
+	} else if err != nil {
+		// Better to invoke a webhook that might have expired than to skip one that has not
+		log.Println("Failed to get webhook", webhook.ID, err.Error())
+		return webhook, true
+	}
+
+	if stored.expired(now) && stored.State != StateExpired {
+		log.Println("Webhook expired:", webhook.ID)
+		_, err = s.store.Update(context.Background(), webhook.ID, func(webhook *Webhook) error {
+			webhook.refreshState(now)
+			return nil
+		})
+		if err != nil {
+			log.Println("Failed to expire webhook", webhook.ID, err.Error())
+		}
+	}
+
+	return stored, stored.Active(now)
+}
+
+// invokeLater invokes a webhook in the background, so that fetching its data does not hold up the Run goroutine,
+// and with it Schedule and Unschedule. Returns false if the webhook is still being invoked from last time.
+func (s *Scheduler) invokeLater(webhook *Webhook) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, busy := s.invoking[webhook.ID]; busy {
+		return false
+	}
+	s.invoking[webhook.ID] = struct{}{}
+
+	// The webhook might be the one in the queue, which the Run goroutine keeps changing
+	data := *webhook
+	go func() {
+		s.invoke(&data)
+
+		s.mu.Lock()
+		delete(s.invoking, data.ID)
+		s.mu.Unlock()
+	}()
+	return true
+}
+
+// invokeExpired starts invoking all the webhooks whose timeout has expired, and reschedules them.
+// Webhooks that are no longer active are dropped instead.
+func (s *Scheduler) invokeExpired() {
+	now := time.Now()
+	for len(s.queue) > 0 && !s.queue[0].at.After(now) {
+		webhook, active := s.current(&s.queue[0].webhook, now)
+		if !active {
+			log.Println("Unscheduling webhook:", s.queue[0].webhook.ID)
+			s.remove(s.queue[0].webhook.ID)
+			continue
+		}
+
+		if s.invokeLater(webhook) {
+			log.Println("Timeout reached, invoking webhook:", webhook.ID)
+		} else {
+			log.Println("Timeout reached, but webhook is still being invoked:", webhook.ID)
+		}
+
+		// Reschedule from now, whether or not the invocation succeeded, so a failing webhook can not hog the scheduler
+		s.set(webhook, nextTimeout(webhook, now))
+	}
+}
+
+// Run loads all the webhooks from the store, then invokes them as their timeouts expire until Stop is called.
+func (s *Scheduler) Run(wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	webhooks, err := s.store.List(context.Background())
+	if err != nil {
+		log.Println("Scheduler failed to load webhooks:", err.Error())
+	}
+	now := time.Now()
+	for i := range webhooks {
+		if webhooks[i].Active(now) {
+			s.set(&webhooks[i], nextTimeout(&webhooks[i], webhooks[i].LastTriggered))
+		}
+	}
+
+	timer := time.NewTimer(0)
+	for {
+		s.resetTimer(timer)
+
+		select {
+		case <-timer.C:
+			s.invokeExpired()
+		case event := <-s.events:
+			s.handle(event)
+		case <-s.quit:
+			timer.Stop()
+			return
+		}
+	}
+}
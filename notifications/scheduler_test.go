@@ -0,0 +1,140 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"container/heap"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTimeoutQueue tests that the queue always has the earliest timeout first, also after timeouts are moved or removed.
+func TestTimeoutQueue(t *testing.T) {
+	s := NewScheduler(NewMemoryStore(), corona.Providers{}, nil, nil)
+	now := time.Now()
+
+	s.set(&Webhook{ID: "a"}, now.Add(3*time.Second))
+	s.set(&Webhook{ID: "b"}, now.Add(1*time.Second))
+	s.set(&Webhook{ID: "c"}, now.Add(2*time.Second))
+	assert.Equal(t, "b", s.queue[0].webhook.ID)
+
+	// Moving a timeout should reorder the queue
+	s.set(&Webhook{ID: "a"}, now)
+	assert.Equal(t, "a", s.queue[0].webhook.ID)
+	assert.Len(t, s.queue, 3, "Moving a timeout should not add a new one")
+
+	s.remove("a")
+	s.remove("does not exist")
+	assert.Equal(t, "b", s.queue[0].webhook.ID)
+
+	order := make([]string, 0)
+	for s.queue.Len() > 0 {
+		order = append(order, heap.Pop(&s.queue).(*timeout).webhook.ID)
+	}
+	assert.Equal(t, []string{"b", "c"}, order)
+}
+
+// TestSchedulerInvokesExpired tests that a scheduled webhook whose timeout has expired is invoked right away.
+func TestSchedulerInvokesExpired(t *testing.T) {
+	delivered := make(chan string, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		delivered <- r.URL.Path
+	}))
+	defer receiver.Close()
+
+	store := NewMemoryStore()
+	providers := corona.Providers{Cases: countingCases{"Norway": 100}}
+	s := NewScheduler(store, providers, startDispatcher(t, store, DefaultDispatcherConfig), nil)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go s.Run(wg)
+	defer func() {
+		s.Stop()
+		wg.Wait()
+	}()
+
+	webhook := Webhook{
+		URL:           receiver.URL + "/hook",
+		Timeout:       3600,
+		Country:       "Norway",
+		Field:         FieldConfirmed,
+		Trigger:       TriggerOnTimeout,
+		LastTriggered: time.Now().Add(-2 * time.Hour),
+	}
+	id, _ := store.Create(context.Background(), &webhook)
+	webhook.ID = id
+	s.Schedule(&webhook)
+
+	select {
+	case path := <-delivered:
+		assert.Equal(t, "/hook", path)
+	case <-time.After(time.Second):
+		t.Fatal("The expired webhook was never invoked")
+	}
+
+	// LastTriggered is updated once the receiver has replied
+	assert.Eventually(t, func() bool {
+		stored, _ := store.Get(context.Background(), id)
+		return time.Since(stored.LastTriggered) < time.Minute
+	}, time.Second, 10*time.Millisecond)
+}
+
+// blockingCases is a CaseProvider that does not answer until release is closed, like a slow upstream api.
+type blockingCases struct {
+	countingCases
+	release chan struct{}
+	// calls counts the calls to GetCases.
+	calls *int32
+}
+
+func (c blockingCases) GetCases(country string) (confirmed, recovered corona.CaseHistory, err *corona.ServerError) {
+	atomic.AddInt32(c.calls, 1)
+	<-c.release
+	return c.countingCases.GetCases(country)
+}
+
+// TestSchedulerDoesNotBlock tests that a slow invocation does not hold up scheduling other webhooks,
+// and that a webhook is not invoked again while it is still being invoked.
+func TestSchedulerDoesNotBlock(t *testing.T) {
+	store := NewMemoryStore()
+	cases := blockingCases{countingCases{"Norway": 100}, make(chan struct{}), new(int32)}
+	s := NewScheduler(store, corona.Providers{Cases: cases}, nil, nil)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go s.Run(wg)
+	defer func() {
+		close(cases.release)
+		s.Stop()
+		wg.Wait()
+	}()
+
+	webhook := Webhook{Timeout: 1, Country: "Norway", Field: FieldConfirmed, Trigger: TriggerOnCondition}
+	webhook.ID, _ = store.Create(context.Background(), &webhook)
+	s.Schedule(&webhook)
+
+	scheduled := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*schedulerBacklog; i++ {
+			s.Schedule(&Webhook{ID: NewID(), Timeout: 3600})
+		}
+		close(scheduled)
+	}()
+
+	select {
+	case <-scheduled:
+	case <-time.After(time.Second):
+		t.Fatal("Scheduling should not wait for invocations to finish")
+	}
+
+	// The webhook comes due again every second, but is still stuck in the first invocation
+	time.Sleep(1100 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(cases.calls))
+}
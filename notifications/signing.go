@@ -0,0 +1,131 @@
+package notifications
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// Headers added to every delivery to a webhook with a secret.
+const (
+	// TimestampHeader is the unix time, in seconds, the delivery was signed at.
+	// Receivers should reject deliveries with old timestamps, so that a captured delivery can not be replayed later.
+	TimestampHeader string = "X-Webhook-Timestamp"
+	// SignatureHeader is a comma separated list of signatures, "sha256=" followed by the hex encoded
+	// HMAC-SHA256 of the timestamp, a ".", and the body. There is one signature per secret that is currently valid.
+	SignatureHeader string = "X-Webhook-Signature"
+	// SignaturePrefix prefixes every signature in the SignatureHeader.
+	SignaturePrefix string = "sha256="
+)
+
+// secretLength is the number of random bytes in a secret generated by NewSecret.
+const secretLength int = 32
+
+// DefaultSecretOverlap is how long the previous secret stays valid after a rotation, unless the request says otherwise.
+const DefaultSecretOverlap = 24 * time.Hour
+
+// MaxSecretOverlap is the longest the previous secret can be kept valid after a rotation.
+const MaxSecretOverlap = 30 * 24 * time.Hour
+
+// NewSecret generates a random secret for signing deliveries.
+func NewSecret() string {
+	return randomHex(secretLength)
+}
+
+// Sign returns the signature of a body sent at timestamp, using secret.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return SignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Signature returns the value of the SignatureHeader for a body sent at timestamp.
+// While the previous secret is still valid after a rotation, the body is signed with both secrets,
+// so the receiver can verify it with whichever one it knows.
+func (w *Webhook) Signature(timestamp string, body []byte, now time.Time) string {
+	signatures := []string{Sign(w.Secret, timestamp, body)}
+	if w.PreviousSecret != "" && w.PreviousSecretExpires != nil && now.Before(*w.PreviousSecretExpires) {
+		signatures = append(signatures, Sign(w.PreviousSecret, timestamp, body))
+	}
+
+	return strings.Join(signatures, ",")
+}
+
+// sign adds the TimestampHeader and SignatureHeader to a request with body, sent at now.
+// Webhooks registered before signing was introduced have no secret until it is rotated, and are not signed.
+func (w *Webhook) sign(req *http.Request, body []byte, now time.Time) {
+	if w.Secret == "" {
+		return
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, w.Signature(timestamp, body, now))
+}
+
+// redact removes the secrets from a webhook, so that they are only ever shown when they are generated.
+func (w *Webhook) redact() {
+	w.Secret = ""
+	w.PreviousSecret = ""
+}
+
+// rotationBody is the body of the response sent back from the secret rotation endpoint.
+type rotationBody struct {
+	Secret                string     `json:"secret"`
+	PreviousSecretExpires *time.Time `json:"previous_secret_expires,omitempty"`
+}
+
+// NewRotateSecretHandler creates a HttpHandler that, given a webhook id, generates a new secret for the webhook.
+// The previous secret stays valid for the number of seconds given by the overlap query parameter,
+// or DefaultSecretOverlap if it is not set, during which deliveries are signed with both.
+func NewRotateSecretHandler(store WebhookStore, scheduler *Scheduler) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		overlap, err := intQuery(r, "overlap", int(DefaultSecretOverlap/time.Second))
+		if err != nil {
+			http.Error(rw, "Invalid overlap; "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if maxOverlap := int(MaxSecretOverlap / time.Second); overlap > maxOverlap {
+			http.Error(rw, "Invalid overlap; Can not be more than "+strconv.Itoa(maxOverlap)+" seconds", http.StatusBadRequest)
+			return
+		}
+
+		secret := NewSecret()
+		webhook, err := store.Update(r.Context(), id, func(webhook *Webhook) error {
+			webhook.PreviousSecret, webhook.PreviousSecretExpires = "", nil
+			if overlap > 0 && webhook.Secret != "" {
+				expires := time.Now().Add(time.Duration(overlap) * time.Second)
+				webhook.PreviousSecret, webhook.PreviousSecretExpires = webhook.Secret, &expires
+			}
+			webhook.Secret = secret
+			return nil
+		})
+		if errors.Is(err, ErrWebhookNotFound) {
+			http.Error(rw, "Invalid webhook id; No webhook registered by that id", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Println(err.Error())
+			http.Error(rw, "Something went wrong trying to rotate the secret", http.StatusInternalServerError)
+			return
+		}
+
+		// The scheduler keeps its own copy of the webhook, which has to sign with the new secret too
+		scheduler.Schedule(webhook)
+
+		log.Println("Rotated secret of webhook:", id)
+		_ = json.NewEncoder(rw).Encode(&rotationBody{secret, webhook.PreviousSecretExpires})
+	}
+}
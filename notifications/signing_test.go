@@ -0,0 +1,94 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSignature tests that deliveries are signed with the previous secret as well, but only until it expires.
+func TestSignature(t *testing.T) {
+	now := time.Now()
+	expires := now.Add(time.Hour)
+	body := []byte(`{"country":"Norway"}`)
+	webhook := Webhook{Secret: "new", PreviousSecret: "old", PreviousSecretExpires: &expires}
+
+	signatures := strings.Split(webhook.Signature("1615000000", body, now), ",")
+	assert.Equal(t, []string{Sign("new", "1615000000", body), Sign("old", "1615000000", body)}, signatures)
+	assert.NotEqual(t, Sign("new", "1615000001", body), signatures[0], "The timestamp should be part of the signature")
+
+	signatures = strings.Split(webhook.Signature("1615000000", body, expires), ",")
+	assert.Equal(t, []string{Sign("new", "1615000000", body)}, signatures, "An expired secret should not sign anything")
+}
+
+// TestSignedDelivery tests that the receiver can verify a delivery using the secret and the timestamp header.
+func TestSignedDelivery(t *testing.T) {
+	verified := make(chan bool, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		timestamp := r.Header.Get(TimestampHeader)
+		verified <- timestamp != "" && r.Header.Get(SignatureHeader) == Sign("secret", timestamp, body)
+	}))
+	defer receiver.Close()
+
+	dispatcher := startDispatcher(t, NewMemoryStore(), DefaultDispatcherConfig)
+	dispatcher.Dispatch(&Webhook{ID: "signed", URL: receiver.URL, Secret: "secret"}, &Snapshot{Field: FieldConfirmed}, ReasonTimeout)
+
+	select {
+	case ok := <-verified:
+		assert.True(t, ok, "The signature should match the body and timestamp")
+	case <-time.After(time.Second):
+		t.Fatal("The delivery never arrived")
+	}
+}
+
+// TestRotateSecret tests that rotating the secret keeps the previous one valid for the requested overlap,
+// and that the secrets are never shown by the read endpoint.
+func TestRotateSecret(t *testing.T) {
+	store := NewMemoryStore()
+	id, _ := store.Create(context.Background(), &Webhook{URL: "http://localhost", Timeout: 60, Secret: "old"})
+
+	r := chi.NewRouter()
+	r.Post(IDPattern+SecretPath, NewRotateSecretHandler(store, NewScheduler(store, corona.Providers{}, nil, nil)))
+	r.Get(IDPattern, NewReadHandler(store))
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/"+id+SecretPath+"?overlap=60", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+
+	var rotation rotationBody
+	assert.NoError(t, json.NewDecoder(rw.Body).Decode(&rotation))
+	assert.Len(t, rotation.Secret, 2*secretLength)
+	if assert.NotNil(t, rotation.PreviousSecretExpires) {
+		assert.WithinDuration(t, time.Now().Add(time.Minute), *rotation.PreviousSecretExpires, time.Second)
+	}
+
+	webhook, _ := store.Get(context.Background(), id)
+	assert.Equal(t, rotation.Secret, webhook.Secret)
+	assert.Equal(t, "old", webhook.PreviousSecret)
+
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/"+id, nil))
+	assert.NotContains(t, rw.Body.String(), rotation.Secret)
+	assert.NotContains(t, rw.Body.String(), `"old"`)
+
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/unknown"+SecretPath, nil))
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+
+	// An overlap that would overflow the expiry is rejected, and leaves the secret alone
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/"+id+SecretPath+"?overlap=9223372036854775807", nil))
+	assert.Equal(t, http.StatusBadRequest, rw.Code)
+	webhook, _ = store.Get(context.Background(), id)
+	assert.Equal(t, rotation.Secret, webhook.Secret)
+}
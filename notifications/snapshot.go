@@ -0,0 +1,131 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Snapshot is the latest data seen for one field of one country.
+// ON_CHANGE webhooks fire when fresh data differs from the snapshot,
+// and snapshots are kept in the WebhookStore so that this survives restarts.
+type Snapshot struct {
+	Country string `json:"country"`
+	Field   string `json:"field"`
+	// Confirmed is only filled out if Field is FieldConfirmed.
+	Confirmed corona.CountryResponse `json:"confirmed"`
+	// Stringency is only filled out if Field is FieldStringency.
+	Stringency corona.PolicyResponse `json:"stringency"`
+	// Seen is when the data was fetched.
+	Seen time.Time `json:"seen"`
+}
+
+// SnapshotKey identifies the snapshot of a field in a country.
+// Country names are case insensitive upstream, so they are here as well.
+func SnapshotKey(country, field string) string {
+	return strings.ToLower(country) + "_" + field
+}
+
+// Body returns the data for the snapshot's field, as sent to webhooks.
+func (s *Snapshot) Body() interface{} {
+	if s.Field == FieldConfirmed {
+		return &s.Confirmed
+	}
+	return &s.Stringency
+}
+
+// SameData returns true if the two snapshots contain the same data, regardless of when they were seen.
+func (s *Snapshot) SameData(other *Snapshot) bool {
+	return s.Confirmed == other.Confirmed && s.Stringency == other.Stringency
+}
+
+// pairLocks serialises the refreshes of each country and field, so that when the poller and scheduler refresh the same
+// pair at once, they do not both compare against the same previous snapshot, and both report the change.
+type pairLocks struct {
+	mu    sync.Mutex
+	locks map[string]*pairLock
+}
+
+// pairLock is the lock of a single pair, which is removed once nobody holds or waits for it.
+type pairLock struct {
+	sync.Mutex
+	users int
+}
+
+// refreshing are the locks of the pairs currently being refreshed.
+var refreshing = pairLocks{locks: make(map[string]*pairLock)}
+
+// lock locks the pair identified by key, and returns the function unlocking it.
+func (l *pairLocks) lock(key string) func() {
+	l.mu.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &pairLock{}
+		l.locks[key] = lock
+	}
+	lock.users++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		l.mu.Lock()
+		lock.users--
+		if lock.users == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// Refresh fetches the latest data for a field in a country, and saves it as the new snapshot for the pair.
+// The stringency of the country is looked up by its alpha3 code, or by its name if the code is empty.
+// Returns the fresh snapshot, and whether it differs from the previous one.
+// The first time a pair is seen there is nothing to compare against, so that does not count as a change.
+// Concurrent refreshes of the same pair are done one at a time, so only one of them reports a change.
+func Refresh(ctx context.Context, store WebhookStore, providers corona.Providers, country, code, field string) (*Snapshot, bool, error) {
+	unlock := refreshing.lock(SnapshotKey(country, field))
+	defer unlock()
+
+	snapshot, err := fetch(providers, country, code, field)
+	if err != nil {
+		return nil, false, err
+	}
+
+	previous, geterr := store.GetSnapshot(ctx, country, field)
+	if geterr != nil && !errors.Is(geterr, ErrSnapshotNotFound) {
+		return nil, false, geterr
+	}
+	changed := previous != nil && !previous.SameData(snapshot)
+
+	puterr := store.PutSnapshot(ctx, snapshot)
+	if puterr != nil {
+		return nil, false, puterr
+	}
+
+	return snapshot, changed, nil
+}
+
+// fetch gets the latest data for a field in a country, without saving it.
+func fetch(providers corona.Providers, country, code, field string) (*Snapshot, *corona.ServerError) {
+	snapshot := Snapshot{Country: country, Field: field, Seen: time.Now()}
+
+	var err *corona.ServerError
+	if field == FieldConfirmed {
+		snapshot.Confirmed, err = corona.GetLatestCases(providers, country)
+	} else if code != "" { // field == FieldStringency
+		// The case provider's name for the country is not necessarily one restcountries knows
+		snapshot.Stringency, err = corona.GetLatestStringencyOf(providers, country, code)
+	} else {
+		snapshot.Stringency, err = corona.GetLatestStringency(providers, country)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
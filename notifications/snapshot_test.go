@@ -0,0 +1,97 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingCases is a CaseProvider where the confirmed count of each country can be changed by the test.
+type countingCases map[string]float64
+
+func (c countingCases) GetCases(country string) (confirmed, recovered corona.CaseHistory, err *corona.ServerError) {
+	confirmed = corona.CaseHistory{Country: country, Dates: map[string]float64{"2021-03-01": c[country]}}
+	return confirmed, recovered, nil
+}
+
+func (c countingCases) GetCaseCountries() ([]corona.CaseCountry, *corona.ServerError) {
+	countries := make([]corona.CaseCountry, 0, len(c))
+	for name := range c {
+		countries = append(countries, corona.CaseCountry{Name: name})
+	}
+	return countries, nil
+}
+
+func (c countingCases) Status() int {
+	return http.StatusOK
+}
+
+// TestRefreshPerCountry tests that changes are tracked separately for each country,
+// so that refreshing one country never makes another look changed.
+func TestRefreshPerCountry(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	cases := countingCases{"Norway": 100, "Sweden": 200}
+	providers := corona.Providers{Cases: cases}
+
+	// The first refresh only establishes a baseline
+	_, changed, err := Refresh(ctx, store, providers, "Norway", "", FieldConfirmed)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	_, changed, _ = Refresh(ctx, store, providers, "Sweden", "", FieldConfirmed)
+	assert.False(t, changed)
+
+	// Alternating between the countries should not look like changes
+	_, changed, _ = Refresh(ctx, store, providers, "Norway", "", FieldConfirmed)
+	assert.False(t, changed, "Refreshing Sweden should not affect Norway")
+
+	cases["Sweden"] = 250
+	snapshot, changed, _ := Refresh(ctx, store, providers, "Sweden", "", FieldConfirmed)
+	assert.True(t, changed)
+	assert.Equal(t, "Sweden", snapshot.Confirmed.Country)
+	assert.Equal(t, 250.0, snapshot.Confirmed.Confirmed)
+
+	_, changed, _ = Refresh(ctx, store, providers, "Norway", "", FieldConfirmed)
+	assert.False(t, changed)
+
+	// The snapshot is kept in the store
+	stored, err := store.GetSnapshot(ctx, "sweden", FieldConfirmed)
+	assert.NoError(t, err)
+	assert.True(t, stored.SameData(snapshot))
+}
+
+// TestRefreshConcurrently tests that when the same pair is refreshed by several goroutines at once,
+// like the poller and scheduler might, only one of them reports the change.
+func TestRefreshConcurrently(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	providers := corona.Providers{Cases: countingCases{"Norway": 100}}
+	_, _, err := Refresh(ctx, store, providers, "Norway", "", FieldConfirmed)
+	assert.NoError(t, err)
+
+	providers.Cases = countingCases{"Norway": 150}
+	var changes int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, changed, _ := Refresh(ctx, store, providers, "Norway", "", FieldConfirmed)
+			if changed {
+				atomic.AddInt32(&changes, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), changes)
+	refreshing.mu.Lock()
+	_, locked := refreshing.locks[SnapshotKey("Norway", FieldConfirmed)]
+	refreshing.mu.Unlock()
+	assert.False(t, locked, "The lock of a pair that is no longer refreshed should be removed")
+}
@@ -0,0 +1,247 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Types of the messages in the socket protocol.
+const (
+	// SocketSubscribe and SocketUnsubscribe are sent by the client to follow and stop following a country and field.
+	SocketSubscribe   string = "subscribe"
+	SocketUnsubscribe string = "unsubscribe"
+	// SocketAck is sent in reply to a subscribe or unsubscribe that succeeded, and SocketError to one that did not.
+	SocketAck   string = "ack"
+	SocketError string = "error"
+	// SocketChange carries a change to the data of a country and field the client follows.
+	SocketChange string = "change"
+)
+
+// Limits on socket connections.
+const (
+	// MaxSocketChannels is how many countries and fields a connection can follow at once.
+	MaxSocketChannels int = 64
+	// maxSocketMessage is the maximum size of a message from the client, in bytes.
+	maxSocketMessage int64 = 1024
+	// socketReplies is how many replies can wait to be written, before reading from the client is paused.
+	socketReplies int = 16
+	// socketWriteWait is how long writing a message to the client may take, before the client is given up on.
+	socketWriteWait = 10 * time.Second
+)
+
+// socketRequest is a message from the client.
+type socketRequest struct {
+	Type string `json:"type"`
+	// ID is echoed back in the reply, so that the client can match them up.
+	ID      string `json:"id,omitempty"`
+	Country string `json:"country"`
+	Field   string `json:"field"`
+}
+
+// socketMessage is a message to the client.
+type socketMessage struct {
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+	// Action is the type of the request an ack is a reply to.
+	Action  string       `json:"action,omitempty"`
+	Country string       `json:"country,omitempty"`
+	Field   string       `json:"field,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	Event   *ChangeEvent `json:"event,omitempty"`
+}
+
+// socket is a client connected to the socket endpoint.
+// Messages from the client are read by one goroutine and written by another, as the connection requires.
+type socket struct {
+	conn      *websocket.Conn
+	broker    *Broker
+	providers corona.Providers
+	sub       *Subscription
+	// follows counts the countries and fields the client follows. Only touched by the reading goroutine.
+	follows int
+	// replies to the client's requests, waiting to be written.
+	replies chan socketMessage
+	// hangup is closed when the client stops sending, and done when the connection is no longer written to.
+	hangup, done chan struct{}
+}
+
+// checkOrigin creates the origin check of the socket endpoint. Without any origins, only the server's own origin
+// is allowed, as by default. An origin of * allows any.
+func checkOrigin(origins []string) func(r *http.Request) bool {
+	if len(origins) == 0 {
+		return nil
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		// Clients that are not browsers do not send an origin, and are not subject to the same-origin policy anyway
+		if origin == "" {
+			return true
+		}
+		for _, allowed := range origins {
+			if allowed == "*" || strings.EqualFold(strings.TrimSpace(allowed), origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// NewSocketHandler creates a HttpHandler that upgrades to a WebSocket, over which the client subscribes to, and
+// unsubscribes from, the changes to the data of countries and fields, as detected by the notification engine.
+// Browsers are allowed to connect from origins, or only the same origin if there are none.
+// The client is pinged every heartbeat, and disconnected if it does not answer in time,
+// or falls too far behind on the changes.
+func NewSocketHandler(broker *Broker, providers corona.Providers, origins []string, heartbeat time.Duration) http.HandlerFunc {
+	upgrader := websocket.Upgrader{CheckOrigin: checkOrigin(origins)}
+	if heartbeat <= 0 {
+		heartbeat = DefaultHeartbeatInterval
+	}
+	return func(rw http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(rw, r, nil)
+		if err != nil {
+			// The upgrader has already responded to the client
+			log.Println("Failed to upgrade to a websocket:", err.Error())
+			return
+		}
+		defer conn.Close()
+
+		s := &socket{
+			conn:      conn,
+			broker:    broker,
+			providers: providers,
+			sub:       broker.Listen(),
+			replies:   make(chan socketMessage, socketReplies),
+			hangup:    make(chan struct{}),
+			done:      make(chan struct{}),
+		}
+		defer broker.Unsubscribe(s.sub)
+		defer close(s.done)
+
+		go s.read(2 * heartbeat) //nolint:gomnd // Give up after a missed ping and a half
+		s.write(heartbeat)
+	}
+}
+
+// read handles the requests of the client until it hangs up, or has not answered a ping within timeout.
+// Reading is paused while the replies wait to be written, so a client that sends faster than it reads is slowed down.
+func (s *socket) read(timeout time.Duration) {
+	defer close(s.hangup)
+
+	s.conn.SetReadLimit(maxSocketMessage)
+	_ = s.conn.SetReadDeadline(time.Now().Add(timeout))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(timeout))
+	})
+
+	for {
+		_, message, err := s.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Println("Socket closed:", err.Error())
+			}
+			return
+		}
+
+		var req socketRequest
+		reply := socketMessage{Type: SocketError, Error: "The message is not valid JSON"}
+		if json.Unmarshal(message, &req) == nil {
+			reply = s.handle(&req)
+		}
+
+		select {
+		case s.replies <- reply:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// handle subscribes or unsubscribes the client, and returns the reply to the request.
+func (s *socket) handle(req *socketRequest) socketMessage {
+	fail := func(message string) socketMessage {
+		return socketMessage{Type: SocketError, ID: req.ID, Error: message}
+	}
+
+	if req.Type != SocketSubscribe && req.Type != SocketUnsubscribe {
+		return fail("The type must be either subscribe or unsubscribe")
+	}
+	if req.Field != FieldStringency && req.Field != FieldConfirmed {
+		return fail("The field supplied does not exits")
+	}
+	if req.Country == "" {
+		return fail("A country must be supplied")
+	}
+
+	// The country is looked up, so that the client is told about typos rather than waiting forever,
+	// and by the name the changes are published under
+	countries, serverErr := corona.CaseCountries(s.providers)
+	if serverErr != nil {
+		log.Println(serverErr.Error())
+		return fail("Something went wrong trying to look up the countries")
+	}
+	country, err := resolveCountry(countries, req.Country)
+	if err != nil {
+		return fail(err.Error())
+	}
+
+	if req.Type == SocketSubscribe {
+		if s.follows >= MaxSocketChannels {
+			return fail(fmt.Sprintf("A connection can subscribe to at most %d countries and fields", MaxSocketChannels))
+		}
+		// Subscribing again is harmless, and the poller checks whatever is followed for changes
+		if s.broker.Follow(s.sub, country.Name, country.Alpha3Code, req.Field) {
+			s.follows++
+		}
+	} else {
+		if !s.broker.Unfollow(s.sub, country.Name, req.Field) {
+			return fail("Not subscribed to that country and field")
+		}
+		s.follows--
+	}
+
+	return socketMessage{Type: SocketAck, ID: req.ID, Action: req.Type, Country: country.Name, Field: req.Field}
+}
+
+// write writes the replies and changes to the client, and pings it every heartbeat, until the client hangs up.
+// A client that falls too far behind on the changes is told to try again later, and disconnected.
+func (s *socket) write(heartbeat time.Duration) {
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		var err error
+		select {
+		case <-s.hangup:
+			return
+		case reply := <-s.replies:
+			err = s.send(&reply)
+		case event, open := <-s.sub.Events():
+			if !open {
+				message := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "Fell too far behind")
+				_ = s.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(socketWriteWait))
+				return
+			}
+			err = s.send(&socketMessage{Type: SocketChange, Country: event.Country, Field: event.Field, Event: &event})
+		case <-ticker.C:
+			err = s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteWait))
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// send writes a message to the client, giving up on it if that takes too long.
+func (s *socket) send(message *socketMessage) error {
+	_ = s.conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+	return s.conn.WriteJSON(message)
+}
@@ -0,0 +1,108 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSocketHandler tests the subscribe and unsubscribe protocol of the socket endpoint,
+// and that subscribers only get the changes they are subscribed to.
+func TestSocketHandler(t *testing.T) {
+	fixtures, err := corona.LoadFixtures("../fixtures")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	broker := NewBroker()
+	server := httptest.NewServer(NewSocketHandler(broker, fixtures.Providers(), nil, time.Minute))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	request := func(req socketRequest) socketMessage {
+		assert.NoError(t, conn.WriteJSON(req))
+		var reply socketMessage
+		assert.NoError(t, conn.ReadJSON(&reply))
+		return reply
+	}
+
+	// Countries are looked up like they are for webhooks
+	reply := request(socketRequest{Type: SocketSubscribe, ID: "1", Country: "norway", Field: FieldConfirmed})
+	assert.Equal(t, socketMessage{Type: SocketAck, ID: "1", Action: SocketSubscribe, Country: "Norway", Field: FieldConfirmed}, reply)
+
+	// By the name the case provider uses, which the changes are published under, and polled by the poller
+	reply = request(socketRequest{Type: SocketSubscribe, ID: "russia", Country: "Russian Federation", Field: FieldStringency})
+	assert.Equal(t, SocketAck, reply.Type)
+	assert.Equal(t, "Russia", reply.Country)
+	store := NewMemoryStore()
+	NewPoller(store, fixtures.Providers(), nil, broker, time.Minute).Poll()
+	_, err = store.GetSnapshot(context.Background(), "Russia", FieldStringency)
+	assert.NoError(t, err, "The poller should check what is followed for changes")
+	reply = request(socketRequest{Type: SocketUnsubscribe, ID: "russia", Country: "russia", Field: FieldStringency})
+	assert.Equal(t, SocketAck, reply.Type)
+
+	failures := []socketRequest{
+		{Type: "follow", ID: "2", Country: "Norway", Field: FieldConfirmed},
+		{Type: SocketSubscribe, ID: "3", Country: "Norway", Field: "deaths"},
+		{Type: SocketSubscribe, ID: "4", Country: "Norwya", Field: FieldConfirmed},
+		{Type: SocketUnsubscribe, ID: "5", Country: "Sweden", Field: FieldConfirmed},
+	}
+	for _, req := range failures {
+		reply = request(req)
+		assert.Equal(t, SocketError, reply.Type)
+		assert.Equal(t, req.ID, reply.ID)
+		assert.NotEmpty(t, reply.Error)
+	}
+
+	assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("{")))
+	assert.NoError(t, conn.ReadJSON(&reply))
+	assert.Equal(t, SocketError, reply.Type)
+
+	// Only the changes to what the client is subscribed to are delivered
+	norway := exampleSnapshot("Norway", FieldConfirmed)
+	sweden := exampleSnapshot("Sweden", FieldConfirmed)
+	broker.Publish(&sweden)
+	broker.Publish(&norway)
+	assert.NoError(t, conn.ReadJSON(&reply))
+	assert.Equal(t, SocketChange, reply.Type)
+	assert.Equal(t, "Norway", reply.Country)
+	if assert.NotNil(t, reply.Event) {
+		assert.Equal(t, FieldConfirmed, reply.Event.Field)
+	}
+
+	reply = request(socketRequest{Type: SocketUnsubscribe, ID: "6", Country: "Norway", Field: FieldConfirmed})
+	assert.Equal(t, SocketAck, reply.Type)
+	assert.Equal(t, SocketUnsubscribe, reply.Action)
+
+	// The subscriber is removed when the client disconnects
+	assert.Equal(t, 1, broker.Subscribers())
+	conn.Close()
+	assert.Eventually(t, func() bool { return broker.Subscribers() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+// TestCheckOrigin tests that browsers can only connect from the allowed origins.
+func TestCheckOrigin(t *testing.T) {
+	assert.Nil(t, checkOrigin(nil))
+
+	check := checkOrigin([]string{"https://dashboard.example.com"})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.True(t, check(r))
+	r.Header.Set("Origin", "https://dashboard.example.com")
+	assert.True(t, check(r))
+	r.Header.Set("Origin", "https://evil.example.com")
+	assert.False(t, check(r))
+
+	assert.True(t, checkOrigin([]string{"*"})(r))
+}
@@ -0,0 +1,118 @@
+package notifications
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// States a webhook can be in.
+const (
+	// StatePending webhooks have not passed the verification challenge yet, and are not invoked until they do.
+	StatePending string = "pending"
+	// StateActive webhooks are invoked as usual.
+	StateActive string = "active"
+	// StatePaused webhooks are kept, but not invoked until they are resumed.
+	StatePaused string = "paused"
+	// StateExpired webhooks have passed their expiry date or used up their invocations, and are never invoked again.
+	StateExpired string = "expired"
+)
+
+// errExpired is returned when trying to pause or resume a webhook that has expired.
+var errExpired = errors.New("webhook has expired")
+
+// errPending is returned when trying to pause or resume a webhook that has not been verified.
+var errPending = errors.New("webhook has not been verified")
+
+// expired returns true if the webhook has passed its expiry date, or used up its invocations, at now.
+func (w *Webhook) expired(now time.Time) bool {
+	if w.Expires != nil && !now.Before(*w.Expires) {
+		return true
+	}
+	return w.MaxInvocations > 0 && w.Invocations >= w.MaxInvocations
+}
+
+// Active returns true if the webhook should be invoked at now.
+// Webhooks registered before states were introduced have no state, and are active.
+func (w *Webhook) Active(now time.Time) bool {
+	return (w.State == "" || w.State == StateActive) && !w.expired(now)
+}
+
+// refreshState brings the state up to date with now, in case the webhook expired since its state was stored.
+func (w *Webhook) refreshState(now time.Time) {
+	if w.expired(now) {
+		w.State = StateExpired
+	} else if w.State == "" {
+		w.State = StateActive
+	}
+}
+
+// validateExpires checks that an expiry date, if there is one, is in the future.
+func validateExpires(expires *time.Time) error {
+	if expires != nil && !expires.After(time.Now()) {
+		return validationError("The expiry date must be in the future")
+	}
+
+	return nil
+}
+
+// newStateHandler creates a HttpHandler that, given a webhook id, moves the webhook into state,
+// and tells the scheduler about it.
+func newStateHandler(store WebhookStore, scheduler *Scheduler, state string) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		now := time.Now()
+		webhook, err := store.Update(r.Context(), id, func(webhook *Webhook) error {
+			// The state says expired until it is changed, so an expired webhook whose expires or max_invocations
+			// has been extended since can be brought back
+			if webhook.expired(now) {
+				return errExpired
+			}
+			webhook.refreshState(now)
+			if webhook.State == StatePending {
+				return errPending
+			}
+
+			webhook.State = state
+			return nil
+		})
+		if errors.Is(err, errExpired) {
+			http.Error(rw, "The webhook has expired; extend its expires or max_invocations before resuming it",
+				http.StatusConflict)
+			return
+		} else if errors.Is(err, errPending) {
+			http.Error(rw, "The webhook has not been verified; verify it before pausing or resuming it", http.StatusConflict)
+			return
+		} else if errors.Is(err, ErrWebhookNotFound) {
+			http.Error(rw, "Invalid webhook id; No webhook registered by that id", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Println(err.Error())
+			http.Error(rw, "Something went wrong trying to update the webhook", http.StatusInternalServerError)
+			return
+		}
+
+		// The scheduler drops paused webhooks, and picks resumed ones back up
+		scheduler.Schedule(webhook)
+
+		log.Println("Webhook", id, "is now", state)
+		webhook.display(time.Now())
+		_ = json.NewEncoder(rw).Encode(webhook)
+	}
+}
+
+// NewPauseHandler creates a HttpHandler that, given a webhook id, stops the webhook from being invoked until it is resumed.
+func NewPauseHandler(store WebhookStore, scheduler *Scheduler) http.HandlerFunc {
+	return newStateHandler(store, scheduler, StatePaused)
+}
+
+// NewResumeHandler creates a HttpHandler that, given a webhook id, makes a paused webhook active again.
+// An expired webhook can be resumed too, once its expiry date or max invocations has been extended.
+func NewResumeHandler(store WebhookStore, scheduler *Scheduler) http.HandlerFunc {
+	return newStateHandler(store, scheduler, StateActive)
+}
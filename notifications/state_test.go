@@ -0,0 +1,122 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestActive tests that only active webhooks that have not passed their expiry date or max invocations are invoked.
+func TestActive(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+
+	assert.True(t, (&Webhook{}).Active(now), "Webhooks without a state should be active")
+	assert.True(t, (&Webhook{State: StateActive, Expires: &future, MaxInvocations: 2, Invocations: 1}).Active(now))
+	assert.False(t, (&Webhook{State: StatePaused}).Active(now))
+	assert.False(t, (&Webhook{State: StateActive, Expires: &past}).Active(now))
+	assert.False(t, (&Webhook{State: StateActive, MaxInvocations: 2, Invocations: 2}).Active(now))
+
+	webhook := Webhook{State: StatePaused, Expires: &past}
+	webhook.refreshState(now)
+	assert.Equal(t, StateExpired, webhook.State, "A paused webhook should expire as well")
+}
+
+// TestPauseResume tests that webhooks can be paused and resumed, but not once they have expired,
+// until their max invocations is extended.
+func TestPauseResume(t *testing.T) {
+	store := NewMemoryStore()
+	id, _ := store.Create(context.Background(), &Webhook{URL: "http://localhost", Timeout: 60, State: StateActive})
+	expired, _ := store.Create(context.Background(), &Webhook{
+		URL: "http://localhost", Country: "Norway", Field: FieldConfirmed, Trigger: TriggerOnTimeout, Timeout: 60,
+		MaxInvocations: 1, Invocations: 1, State: StateExpired,
+	})
+
+	scheduler := NewScheduler(store, corona.Providers{}, nil, nil)
+	r := chi.NewRouter()
+	r.Post(IDPattern+PausePath, NewPauseHandler(store, scheduler))
+	r.Post(IDPattern+ResumePath, NewResumeHandler(store, scheduler))
+	post := func(path string) (int, Webhook) {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, path, nil))
+
+		var webhook Webhook
+		_ = json.NewDecoder(rw.Body).Decode(&webhook)
+		return rw.Code, webhook
+	}
+
+	code, webhook := post("/" + id + PausePath)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatePaused, webhook.State)
+	event := <-scheduler.events
+	assert.False(t, event.webhook.Active(time.Now()), "The scheduler should be told the webhook is paused")
+
+	code, webhook = post("/" + id + ResumePath)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StateActive, webhook.State)
+	event = <-scheduler.events
+	assert.True(t, event.webhook.Active(time.Now()), "The scheduler should be told the webhook is active again")
+
+	code, _ = post("/" + expired + ResumePath)
+	assert.Equal(t, http.StatusConflict, code)
+
+	// Once its max invocations has been extended, an expired webhook can be resumed
+	r.Patch(IDPattern, NewUpdateHandler(store, corona.Providers{}, scheduler, nil))
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodPatch, "/"+expired, strings.NewReader(`{"max_invocations": 2}`)))
+	if !assert.Equal(t, http.StatusOK, rw.Code, rw.Body.String()) {
+		return
+	}
+	<-scheduler.events
+	code, webhook = post("/" + expired + ResumePath)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StateActive, webhook.State)
+	event = <-scheduler.events
+	assert.True(t, event.webhook.Active(time.Now()), "The scheduler should be told the webhook is active again")
+	code, _ = post("/unknown" + PausePath)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+// TestMaxInvocations tests that a webhook expires after its last allowed delivery, and is not invoked by the scheduler after that.
+func TestMaxInvocations(t *testing.T) {
+	delivered := make(chan struct{}, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		delivered <- struct{}{}
+	}))
+	defer receiver.Close()
+
+	store := NewMemoryStore()
+	webhook := Webhook{URL: receiver.URL, Timeout: 3600, Country: "Norway", Field: FieldConfirmed, MaxInvocations: 1}
+	id, _ := store.Create(context.Background(), &webhook)
+	webhook.ID = id
+
+	providers := corona.Providers{Cases: countingCases{"Norway": 100}}
+	scheduler := NewScheduler(store, providers, startDispatcher(t, store, DefaultDispatcherConfig), nil)
+
+	// Invoke it once, which uses up its only invocation
+	scheduler.set(&webhook, time.Now())
+	scheduler.invokeExpired()
+	<-delivered
+	assert.Eventually(t, func() bool {
+		stored, _ := store.Get(context.Background(), id)
+		return stored.State == StateExpired && stored.Invocations == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// The next time it is due, it is dropped instead
+	scheduler.set(&webhook, time.Now())
+	scheduler.invokeExpired()
+	assert.Empty(t, scheduler.queue, "An expired webhook should be unscheduled")
+	select {
+	case <-delivered:
+		t.Fatal("An expired webhook should not be invoked")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
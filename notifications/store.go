@@ -0,0 +1,41 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrWebhookNotFound is returned by a WebhookStore when there is no webhook registered by the given id.
+var ErrWebhookNotFound = errors.New("no webhook registered by that id")
+
+// ErrSnapshotNotFound is returned by a WebhookStore when no data has been seen yet for a country and field.
+var ErrSnapshotNotFound = errors.New("no snapshot of that country and field")
+
+// WebhookStore is where registered webhooks are kept between invocations.
+// Implementations must be safe for concurrent use, since the http handlers and the invocation loop share one store.
+type WebhookStore interface {
+	// Create registers a new webhook and returns the id it was given.
+	Create(ctx context.Context, webhook *Webhook) (string, error)
+	// Get returns the webhook registered by id, or ErrWebhookNotFound.
+	Get(ctx context.Context, id string) (*Webhook, error)
+	// List returns all the registered webhooks with their ID field filled out.
+	List(ctx context.Context) ([]Webhook, error)
+	// Delete removes the webhook registered by id and its delivery history, or returns ErrWebhookNotFound.
+	Delete(ctx context.Context, id string) error
+	// Update applies update to the webhook registered by id, as one atomic operation, and returns the result.
+	// Returns ErrWebhookNotFound if there is no such webhook, or the error from update, in which case nothing changes.
+	Update(ctx context.Context, id string, update func(webhook *Webhook) error) (*Webhook, error)
+	// Count returns the number of registered webhooks.
+	Count(ctx context.Context) (int, error)
+	// GetSnapshot returns the last data seen for a field in a country, or ErrSnapshotNotFound.
+	GetSnapshot(ctx context.Context, country, field string) (*Snapshot, error)
+	// PutSnapshot replaces the last data seen for the snapshot's field and country.
+	PutSnapshot(ctx context.Context, snapshot *Snapshot) error
+	// Close releases any resources held by the store.
+	Close() error
+
+	// Deliveries that run out of retries are kept in the same store as the webhooks.
+	DeadLetterStore
+	// So is the history of deliveries to each webhook.
+	DeliveryLog
+}
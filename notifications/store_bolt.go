@@ -0,0 +1,393 @@
+package notifications
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltFileMode is the permissions given to the database file if it has to be created.
+const boltFileMode os.FileMode = 0600
+
+// boltOpenTimeout is how long to wait for the lock on the database file, in case another process holds it.
+const boltOpenTimeout = 5 * time.Second
+
+// Bolt buckets, named after the equivalent firestore collections.
+var (
+	// webhookBucket contains all the webhooks currently registered.
+	webhookBucket = []byte(WebhookCollection)
+	// snapshotBucket contains the last data seen for each country and field.
+	snapshotBucket = []byte(SnapshotCollection)
+	// deadLetterBucket contains deliveries that failed even after being retried.
+	deadLetterBucket = []byte(DeadLetterCollection)
+	// deliveryBucket contains a bucket per webhook, with the history of deliveries to it keyed by sequence number.
+	deliveryBucket = []byte(DeliveryCollection)
+)
+
+// BoltStore is a WebhookStore that persists webhooks to a single file on disk using bbolt.
+// It requires no external services, which makes it a good fit for self-hosted deployments.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens, or creates, the database file at path and returns a webhook store backed by it.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, boltFileMode, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure the buckets exist, so that the rest of the store can assume they do
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{webhookBucket, snapshotBucket, deadLetterBucket, deliveryBucket} {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db}, nil
+}
+
+// getWebhook reads and decodes the webhook registered by id from within a transaction.
+func getWebhook(tx *bolt.Tx, id string) (*Webhook, error) {
+	value := tx.Bucket(webhookBucket).Get([]byte(id))
+	if value == nil {
+		return nil, ErrWebhookNotFound
+	}
+
+	var data Webhook
+	err := json.Unmarshal(value, &data)
+	if err != nil {
+		return nil, err
+	}
+	data.ID = id
+
+	return &data, nil
+}
+
+// putWebhook encodes and writes the webhook by id from within a transaction.
+func putWebhook(tx *bolt.Tx, id string, webhook *Webhook) error {
+	value, err := json.Marshal(webhook)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(webhookBucket).Put([]byte(id), value)
+}
+
+// Create registers a new webhook and returns the id it was given.
+func (s *BoltStore) Create(ctx context.Context, webhook *Webhook) (string, error) {
+	var id string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		// Make sure we never hand out the same id twice
+		id = NewID()
+		for tx.Bucket(webhookBucket).Get([]byte(id)) != nil {
+			id = NewID()
+		}
+
+		data := *webhook
+		data.ID = id
+		return putWebhook(tx, id, &data)
+	})
+
+	return id, err
+}
+
+// Get returns the webhook registered by id.
+func (s *BoltStore) Get(ctx context.Context, id string) (*Webhook, error) {
+	var data *Webhook
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		data, err = getWebhook(tx, id)
+		return err
+	})
+
+	return data, err
+}
+
+// List returns all the registered webhooks, sorted by id.
+func (s *BoltStore) List(ctx context.Context) ([]Webhook, error) {
+	webhooks := make([]Webhook, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// Bolt keeps keys sorted, so the webhooks come out sorted by id
+		return tx.Bucket(webhookBucket).ForEach(func(key, value []byte) error {
+			var data Webhook
+			err := json.Unmarshal(value, &data)
+			if err != nil {
+				return err
+			}
+			data.ID = string(key)
+
+			webhooks = append(webhooks, data)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// Delete removes the webhook registered by id, and its delivery history.
+func (s *BoltStore) Delete(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(webhookBucket)
+		if bucket.Get([]byte(id)) == nil {
+			return ErrWebhookNotFound
+		}
+
+		err := tx.Bucket(deliveryBucket).DeleteBucket([]byte(id))
+		if err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+
+		return bucket.Delete([]byte(id))
+	})
+}
+
+// Update applies update to the webhook registered by id.
+func (s *BoltStore) Update(ctx context.Context, id string, update func(webhook *Webhook) error) (*Webhook, error) {
+	var data *Webhook
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		data, err = getWebhook(tx, id)
+		if err != nil {
+			return err
+		}
+
+		err = update(data)
+		if err != nil {
+			return err
+		}
+		data.ID = id
+
+		return putWebhook(tx, id, data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Count returns the number of registered webhooks.
+func (s *BoltStore) Count(ctx context.Context) (int, error) {
+	var count int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(webhookBucket).Stats().KeyN
+		return nil
+	})
+
+	return count, err
+}
+
+// GetSnapshot returns the last data seen for a field in a country.
+func (s *BoltStore) GetSnapshot(ctx context.Context, country, field string) (*Snapshot, error) {
+	var snapshot Snapshot
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(snapshotBucket).Get([]byte(SnapshotKey(country, field)))
+		if value == nil {
+			return ErrSnapshotNotFound
+		}
+
+		return json.Unmarshal(value, &snapshot)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+// PutSnapshot replaces the last data seen for the snapshot's field and country.
+func (s *BoltStore) PutSnapshot(ctx context.Context, snapshot *Snapshot) error {
+	value, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(snapshotBucket).Put([]byte(SnapshotKey(snapshot.Country, snapshot.Field)), value)
+	})
+}
+
+// AddDeadLetter stores a failed delivery and returns the id it was given.
+func (s *BoltStore) AddDeadLetter(ctx context.Context, letter *DeadLetter) (string, error) {
+	var id string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(deadLetterBucket)
+		id = NewID()
+		for bucket.Get([]byte(id)) != nil {
+			id = NewID()
+		}
+
+		data := *letter
+		data.ID = id
+		value, err := json.Marshal(&data)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(id), value)
+	})
+
+	return id, err
+}
+
+// GetDeadLetter returns the dead letter by id.
+func (s *BoltStore) GetDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	var letter DeadLetter
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(deadLetterBucket).Get([]byte(id))
+		if value == nil {
+			return ErrDeadLetterNotFound
+		}
+
+		return json.Unmarshal(value, &letter)
+	})
+	if err != nil {
+		return nil, err
+	}
+	letter.ID = id
+
+	return &letter, nil
+}
+
+// ListDeadLetters returns all the dead letters, oldest first.
+func (s *BoltStore) ListDeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	letters := make([]DeadLetter, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(deadLetterBucket).ForEach(func(key, value []byte) error {
+			var letter DeadLetter
+			err := json.Unmarshal(value, &letter)
+			if err != nil {
+				return err
+			}
+			letter.ID = string(key)
+
+			letters = append(letters, letter)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].Failed.Before(letters[j].Failed) })
+
+	return letters, nil
+}
+
+// DeleteDeadLetter removes the dead letter by id.
+func (s *BoltStore) DeleteDeadLetter(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(deadLetterBucket)
+		if bucket.Get([]byte(id)) == nil {
+			return ErrDeadLetterNotFound
+		}
+
+		return bucket.Delete([]byte(id))
+	})
+}
+
+// AddDelivery records an attempt at delivering to the record's webhook.
+func (s *BoltStore) AddDelivery(ctx context.Context, record *DeliveryRecord) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		// A delivery that was in flight when its webhook was deleted would otherwise bring back its history
+		if tx.Bucket(webhookBucket).Get([]byte(record.WebhookID)) == nil {
+			return nil
+		}
+
+		bucket, err := tx.Bucket(deliveryBucket).CreateBucketIfNotExists([]byte(record.WebhookID))
+		if err != nil {
+			return err
+		}
+
+		// Big endian sequence numbers keep the records sorted oldest first
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8) //nolint:gomnd // Size of an uint64
+		binary.BigEndian.PutUint64(key, seq)
+		err = bucket.Put(key, value)
+		if err != nil {
+			return err
+		}
+
+		// Discard the oldest records beyond the limit
+		count := 0
+		cursor := bucket.Cursor()
+		for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+			count++
+		}
+		for ; count > DeliveryHistoryLimit; count-- {
+			k, _ := cursor.First()
+			err = bucket.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// ListDeliveries returns a page of the attempts at delivering to a webhook, newest first.
+func (s *BoltStore) ListDeliveries(ctx context.Context, webhookID string, offset, limit int) ([]DeliveryRecord, int, error) {
+	records := make([]DeliveryRecord, 0, limit)
+	total := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(deliveryBucket).Bucket([]byte(webhookID))
+		if bucket == nil {
+			return nil // Nothing delivered yet
+		}
+		total = bucket.Stats().KeyN
+
+		cursor := bucket.Cursor()
+		skipped := 0
+		for k, value := cursor.Last(); k != nil && len(records) < limit; k, value = cursor.Prev() {
+			if skipped < offset {
+				skipped++
+				continue
+			}
+
+			var record DeliveryRecord
+			err := json.Unmarshal(value, &record)
+			if err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return records, total, nil
+}
+
+// Close closes the database file.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,48 @@
+package notifications
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBoltStorePersists tests that webhooks written to the bolt store survive the store being closed and reopened.
+func TestBoltStorePersists(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "webhooks.db")
+
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	triggered := time.Now().Round(time.Second)
+	id, err := store.Create(ctx, &Webhook{
+		URL: "http://localhost", Timeout: 60, Field: FieldStringency, Country: "Sweden", LastTriggered: triggered,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, store.Close())
+
+	// Reopen the same file, as if the server was restarted
+	store, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	webhooks, err := store.List(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, webhooks, 1)
+	assert.Equal(t, id, webhooks[0].ID)
+	assert.Equal(t, "Sweden", webhooks[0].Country)
+	assert.True(t, webhooks[0].LastTriggered.Equal(triggered), "LastTriggered should survive a restart")
+
+	count, _ := store.Count(ctx)
+	assert.Equal(t, 1, count)
+
+	assert.NoError(t, store.Delete(ctx, id))
+	assert.ErrorIs(t, store.Delete(ctx, id), ErrWebhookNotFound)
+}
@@ -0,0 +1,300 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FirestoreStore is a WebhookStore backed by a firestore collection.
+type FirestoreStore struct {
+	client *firestore.Client
+}
+
+// NewFirestoreStore creates a webhook store that keeps the webhooks in the WebhookCollection of the given client.
+func NewFirestoreStore(client *firestore.Client) *FirestoreStore {
+	return &FirestoreStore{client}
+}
+
+// collection returns the collection the webhooks are stored in.
+func (s *FirestoreStore) collection() *firestore.CollectionRef {
+	return s.client.Collection(WebhookCollection)
+}
+
+// notFound translates firestore's not found errors into ErrWebhookNotFound.
+func notFound(err error) error {
+	if status.Code(err) == codes.NotFound {
+		return ErrWebhookNotFound
+	}
+	return err
+}
+
+// Create registers a new webhook and returns the id it was given.
+func (s *FirestoreStore) Create(ctx context.Context, webhook *Webhook) (string, error) {
+	docref, _, err := s.collection().Add(ctx, webhook)
+	if err != nil {
+		return "", err
+	}
+
+	return docref.ID, nil
+}
+
+// Get returns the webhook registered by id.
+func (s *FirestoreStore) Get(ctx context.Context, id string) (*Webhook, error) {
+	docsnap, err := s.collection().Doc(id).Get(ctx)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	var data Webhook
+	err = docsnap.DataTo(&data)
+	if err != nil {
+		return nil, err
+	}
+	data.ID = docsnap.Ref.ID
+
+	return &data, nil
+}
+
+// List returns all the registered webhooks.
+func (s *FirestoreStore) List(ctx context.Context) ([]Webhook, error) {
+	docsnaps, err := s.collection().Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := make([]Webhook, 0, len(docsnaps))
+	for _, docsnap := range docsnaps {
+		var data Webhook
+		err = docsnap.DataTo(&data)
+		if err != nil {
+			return nil, err
+		}
+		data.ID = docsnap.Ref.ID
+
+		webhooks = append(webhooks, data)
+	}
+
+	return webhooks, nil
+}
+
+// deliveries returns the collection the delivery history of the webhook registered by id is stored in.
+func (s *FirestoreStore) deliveries(id string) *firestore.CollectionRef {
+	return s.collection().Doc(id).Collection(DeliveryCollection)
+}
+
+// deleteAll deletes all the documents matching query.
+func deleteAll(ctx context.Context, query firestore.Query) error {
+	docsnaps, err := query.Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, docsnap := range docsnaps {
+		_, err = docsnap.Ref.Delete(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes the webhook registered by id, and its delivery history.
+func (s *FirestoreStore) Delete(ctx context.Context, id string) error {
+	_, err := s.collection().Doc(id).Delete(ctx, firestore.Exists)
+	if err != nil {
+		return notFound(err)
+	}
+
+	// Firestore does not delete subcollections along with their parent document
+	return deleteAll(ctx, s.deliveries(id).Query)
+}
+
+// Update applies update to the webhook registered by id, in a transaction.
+func (s *FirestoreStore) Update(ctx context.Context, id string, update func(webhook *Webhook) error) (*Webhook, error) {
+	var data Webhook
+	docref := s.collection().Doc(id)
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		docsnap, err := tx.Get(docref)
+		if err != nil {
+			return notFound(err)
+		}
+
+		// The transaction might be retried, so start over from what is in the store every time
+		data = Webhook{}
+		err = docsnap.DataTo(&data)
+		if err != nil {
+			return err
+		}
+
+		err = update(&data)
+		if err != nil {
+			return err
+		}
+		data.ID = id
+
+		return tx.Set(docref, &data)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+// Count returns the number of registered webhooks.
+func (s *FirestoreStore) Count(ctx context.Context) (int, error) {
+	docrefs, err := s.collection().DocumentRefs(ctx).GetAll()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(docrefs), nil
+}
+
+// GetSnapshot returns the last data seen for a field in a country.
+func (s *FirestoreStore) GetSnapshot(ctx context.Context, country, field string) (*Snapshot, error) {
+	docsnap, err := s.client.Collection(SnapshotCollection).Doc(SnapshotKey(country, field)).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrSnapshotNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var snapshot Snapshot
+	err = docsnap.DataTo(&snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+// PutSnapshot replaces the last data seen for the snapshot's field and country.
+func (s *FirestoreStore) PutSnapshot(ctx context.Context, snapshot *Snapshot) error {
+	_, err := s.client.
+		Collection(SnapshotCollection).
+		Doc(SnapshotKey(snapshot.Country, snapshot.Field)).
+		Set(ctx, snapshot)
+	return err
+}
+
+// AddDeadLetter stores a failed delivery and returns the id it was given.
+func (s *FirestoreStore) AddDeadLetter(ctx context.Context, letter *DeadLetter) (string, error) {
+	docref, _, err := s.client.Collection(DeadLetterCollection).Add(ctx, letter)
+	if err != nil {
+		return "", err
+	}
+
+	return docref.ID, nil
+}
+
+// GetDeadLetter returns the dead letter by id.
+func (s *FirestoreStore) GetDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	docsnap, err := s.client.Collection(DeadLetterCollection).Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrDeadLetterNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var letter DeadLetter
+	err = docsnap.DataTo(&letter)
+	if err != nil {
+		return nil, err
+	}
+	letter.ID = docsnap.Ref.ID
+
+	return &letter, nil
+}
+
+// ListDeadLetters returns all the dead letters, oldest first.
+func (s *FirestoreStore) ListDeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	docsnaps, err := s.client.Collection(DeadLetterCollection).OrderBy("Failed", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]DeadLetter, 0, len(docsnaps))
+	for _, docsnap := range docsnaps {
+		var letter DeadLetter
+		err = docsnap.DataTo(&letter)
+		if err != nil {
+			return nil, err
+		}
+		letter.ID = docsnap.Ref.ID
+
+		letters = append(letters, letter)
+	}
+
+	return letters, nil
+}
+
+// DeleteDeadLetter removes the dead letter by id.
+func (s *FirestoreStore) DeleteDeadLetter(ctx context.Context, id string) error {
+	_, err := s.client.Collection(DeadLetterCollection).Doc(id).Delete(ctx, firestore.Exists)
+	if status.Code(err) == codes.NotFound {
+		return ErrDeadLetterNotFound
+	}
+	return err
+}
+
+// AddDelivery records an attempt at delivering to the record's webhook, unless the webhook has been deleted.
+// The webhook is read in the same transaction, so the record can not be added as the webhook is being deleted.
+func (s *FirestoreStore) AddDelivery(ctx context.Context, record *DeliveryRecord) error {
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		_, err := tx.Get(s.collection().Doc(record.WebhookID))
+		if err != nil {
+			return notFound(err)
+		}
+		return tx.Create(s.deliveries(record.WebhookID).NewDoc(), record)
+	})
+	if errors.Is(err, ErrWebhookNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// Discard the oldest records beyond the limit
+	return deleteAll(ctx, s.deliveries(record.WebhookID).OrderBy("Time", firestore.Desc).Offset(DeliveryHistoryLimit))
+}
+
+// ListDeliveries returns a page of the attempts at delivering to a webhook, newest first.
+func (s *FirestoreStore) ListDeliveries(ctx context.Context, webhookID string, offset, limit int) ([]DeliveryRecord, int, error) {
+	docrefs, err := s.deliveries(webhookID).DocumentRefs(ctx).GetAll()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	docsnaps, err := s.deliveries(webhookID).
+		OrderBy("Time", firestore.Desc).
+		Offset(offset).
+		Limit(limit).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	records := make([]DeliveryRecord, 0, len(docsnaps))
+	for _, docsnap := range docsnaps {
+		var record DeliveryRecord
+		err = docsnap.DataTo(&record)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		records = append(records, record)
+	}
+
+	return records, len(docrefs), nil
+}
+
+// Close closes the underlying firestore client.
+func (s *FirestoreStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,290 @@
+package notifications
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a WebhookStore that keeps the webhooks in memory.
+// Everything is lost when the server stops, so it is mostly useful for development and testing.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	webhooks  map[string]Webhook
+	snapshots map[string]Snapshot
+	letters   map[string]DeadLetter
+	// deliveries to each webhook, oldest first.
+	deliveries map[string][]DeliveryRecord
+}
+
+// copyWebhook returns a deep copy of a webhook, so that neither the stored webhooks nor those handed out
+// share any data the other side could change.
+func copyWebhook(webhook *Webhook) Webhook {
+	data := *webhook
+	if webhook.Condition != nil {
+		condition := *webhook.Condition
+		if condition.Reference != nil {
+			reference := *condition.Reference
+			condition.Reference = &reference
+		}
+		data.Condition = &condition
+	}
+	data.Expires = copyTime(webhook.Expires)
+	data.PreviousSecretExpires = copyTime(webhook.PreviousSecretExpires)
+	data.NextRun = copyTime(webhook.NextRun)
+	data.Countries = copyStrings(webhook.Countries)
+	data.CountryCodes = copyStrings(webhook.CountryCodes)
+	data.Fields = copyStrings(webhook.Fields)
+	return data
+}
+
+// copyTime returns a copy of t, or nil.
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
+
+// copyStrings returns a copy of s, or nil.
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string(nil), s...)
+}
+
+// copyDeadLetter returns a deep copy of a dead letter, like copyWebhook.
+func copyDeadLetter(letter *DeadLetter) DeadLetter {
+	data := *letter
+	if letter.Snapshots != nil {
+		data.Snapshots = append([]Snapshot(nil), letter.Snapshots...)
+	}
+	if letter.Snapshot != nil {
+		snapshot := *letter.Snapshot
+		data.Snapshot = &snapshot
+	}
+	return data
+}
+
+// NewMemoryStore creates an empty in-memory webhook store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		webhooks:   make(map[string]Webhook),
+		snapshots:  make(map[string]Snapshot),
+		letters:    make(map[string]DeadLetter),
+		deliveries: make(map[string][]DeliveryRecord),
+	}
+}
+
+// Create registers a new webhook and returns the id it was given.
+func (s *MemoryStore) Create(ctx context.Context, webhook *Webhook) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Make sure we never hand out the same id twice
+	id := NewID()
+	for _, exists := s.webhooks[id]; exists; _, exists = s.webhooks[id] {
+		id = NewID()
+	}
+
+	data := copyWebhook(webhook)
+	data.ID = id
+	s.webhooks[id] = data
+
+	return id, nil
+}
+
+// Get returns the webhook registered by id.
+func (s *MemoryStore) Get(ctx context.Context, id string) (*Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.webhooks[id]
+	if !ok {
+		return nil, ErrWebhookNotFound
+	}
+
+	webhook := copyWebhook(&data)
+	return &webhook, nil
+}
+
+// List returns all the registered webhooks, sorted by id so the order is stable between calls.
+func (s *MemoryStore) List(ctx context.Context) ([]Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	webhooks := make([]Webhook, 0, len(s.webhooks))
+	for id := range s.webhooks {
+		data := s.webhooks[id]
+		webhooks = append(webhooks, copyWebhook(&data))
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].ID < webhooks[j].ID })
+
+	return webhooks, nil
+}
+
+// Delete removes the webhook registered by id, and its delivery history.
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.webhooks[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(s.webhooks, id)
+	delete(s.deliveries, id)
+
+	return nil
+}
+
+// Update applies update to the webhook registered by id.
+func (s *MemoryStore) Update(ctx context.Context, id string, update func(webhook *Webhook) error) (*Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.webhooks[id]
+	if !ok {
+		return nil, ErrWebhookNotFound
+	}
+	// The update works on a copy, so that nothing is changed if it fails
+	data := copyWebhook(&stored)
+	err := update(&data)
+	if err != nil {
+		return nil, err
+	}
+	data.ID = id
+	s.webhooks[id] = copyWebhook(&data)
+
+	return &data, nil
+}
+
+// Count returns the number of registered webhooks.
+func (s *MemoryStore) Count(ctx context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.webhooks), nil
+}
+
+// GetSnapshot returns the last data seen for a field in a country.
+func (s *MemoryStore) GetSnapshot(ctx context.Context, country, field string) (*Snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot, ok := s.snapshots[SnapshotKey(country, field)]
+	if !ok {
+		return nil, ErrSnapshotNotFound
+	}
+
+	return &snapshot, nil
+}
+
+// PutSnapshot replaces the last data seen for the snapshot's field and country.
+func (s *MemoryStore) PutSnapshot(ctx context.Context, snapshot *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.snapshots[SnapshotKey(snapshot.Country, snapshot.Field)] = *snapshot
+
+	return nil
+}
+
+// AddDeadLetter stores a failed delivery and returns the id it was given.
+func (s *MemoryStore) AddDeadLetter(ctx context.Context, letter *DeadLetter) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := NewID()
+	for _, exists := s.letters[id]; exists; _, exists = s.letters[id] {
+		id = NewID()
+	}
+
+	data := copyDeadLetter(letter)
+	data.ID = id
+	s.letters[id] = data
+
+	return id, nil
+}
+
+// GetDeadLetter returns the dead letter by id.
+func (s *MemoryStore) GetDeadLetter(ctx context.Context, id string) (*DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.letters[id]
+	if !ok {
+		return nil, ErrDeadLetterNotFound
+	}
+
+	letter := copyDeadLetter(&data)
+	return &letter, nil
+}
+
+// ListDeadLetters returns all the dead letters, oldest first.
+func (s *MemoryStore) ListDeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	letters := make([]DeadLetter, 0, len(s.letters))
+	for id := range s.letters {
+		data := s.letters[id]
+		letters = append(letters, copyDeadLetter(&data))
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].Failed.Before(letters[j].Failed) })
+
+	return letters, nil
+}
+
+// DeleteDeadLetter removes the dead letter by id.
+func (s *MemoryStore) DeleteDeadLetter(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.letters[id]; !ok {
+		return ErrDeadLetterNotFound
+	}
+	delete(s.letters, id)
+
+	return nil
+}
+
+// AddDelivery records an attempt at delivering to the record's webhook.
+func (s *MemoryStore) AddDelivery(ctx context.Context, record *DeliveryRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A delivery that was in flight when its webhook was deleted would otherwise bring back its history
+	if _, ok := s.webhooks[record.WebhookID]; !ok {
+		return nil
+	}
+
+	records := append(s.deliveries[record.WebhookID], *record)
+	if len(records) > DeliveryHistoryLimit {
+		records = append([]DeliveryRecord(nil), records[len(records)-DeliveryHistoryLimit:]...)
+	}
+	s.deliveries[record.WebhookID] = records
+
+	return nil
+}
+
+// ListDeliveries returns a page of the attempts at delivering to a webhook, newest first.
+func (s *MemoryStore) ListDeliveries(ctx context.Context, webhookID string, offset, limit int) ([]DeliveryRecord, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := s.deliveries[webhookID]
+	page := make([]DeliveryRecord, 0, limit)
+	for i := len(records) - 1 - offset; i >= 0 && len(page) < limit; i-- {
+		page = append(page, records[i])
+	}
+
+	return page, len(records), nil
+}
+
+// Close does nothing, there is nothing to release.
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,91 @@
+package notifications
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMemoryStore tests that webhooks can be created, read, updated and deleted from the in-memory store.
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	id, err := store.Create(ctx, &Webhook{URL: "http://localhost", Timeout: 60, Field: FieldConfirmed, Country: "Norway"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, id)
+
+	webhook, err := store.Get(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, id, webhook.ID, "The store should fill out the id")
+	assert.Equal(t, "Norway", webhook.Country)
+
+	now := time.Now()
+	_, err = store.Update(ctx, id, func(webhook *Webhook) error {
+		webhook.LastTriggered = now
+		return nil
+	})
+	assert.NoError(t, err)
+	webhook, _ = store.Get(ctx, id)
+	assert.True(t, webhook.LastTriggered.Equal(now), "LastTriggered should be updated")
+
+	count, _ := store.Count(ctx)
+	assert.Equal(t, 1, count)
+
+	assert.NoError(t, store.Delete(ctx, id))
+	_, err = store.Get(ctx, id)
+	assert.ErrorIs(t, err, ErrWebhookNotFound)
+	assert.ErrorIs(t, store.Delete(ctx, id), ErrWebhookNotFound)
+
+	webhooks, _ := store.List(ctx)
+	assert.Empty(t, webhooks)
+}
+
+// TestMemoryStoreCopies tests that the webhooks handed out by the in-memory store do not share any data with the
+// stored ones, so that changing them, or an update that fails, leaves the store alone.
+func TestMemoryStoreCopies(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	reference := 10.0
+	expires := time.Now().Add(time.Hour)
+	webhook := Webhook{
+		Countries: []string{"Norway", "Sweden"}, Expires: &expires,
+		Condition: &Condition{Metric: MetricConfirmed, Operator: OperatorAbove, Value: 5, Reference: &reference},
+	}
+	id, _ := store.Create(ctx, &webhook)
+	webhook.Countries[0] = "Denmark"
+	reference = 20
+
+	got, _ := store.Get(ctx, id)
+	assert.Equal(t, "Norway", got.Countries[0])
+	assert.Equal(t, 10.0, *got.Condition.Reference)
+
+	got.Countries[0] = "Finland"
+	*got.Expires = time.Time{}
+	got.Condition.Value = 0
+	listed, _ := store.List(ctx)
+	assert.Equal(t, "Norway", listed[0].Countries[0])
+	assert.True(t, listed[0].Expires.Equal(expires))
+	assert.Equal(t, 5.0, listed[0].Condition.Value)
+
+	_, err := store.Update(ctx, id, func(webhook *Webhook) error {
+		*webhook.Condition.Reference = 30
+		webhook.Countries[1] = "Iceland"
+		return ErrWebhookNotFound
+	})
+	assert.Error(t, err)
+	got, _ = store.Get(ctx, id)
+	assert.Equal(t, 10.0, *got.Condition.Reference, "A failed update should not change anything")
+	assert.Equal(t, "Sweden", got.Countries[1])
+
+	updated, _ := store.Update(ctx, id, func(webhook *Webhook) error {
+		*webhook.Condition.Reference = 30
+		return nil
+	})
+	*updated.Condition.Reference = 40
+	got, _ = store.Get(ctx, id)
+	assert.Equal(t, 30.0, *got.Condition.Reference)
+}
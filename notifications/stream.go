@@ -0,0 +1,119 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultHeartbeatInterval is how often the event stream sends a comment, to keep idle connections from being closed
+// by proxies along the way, unless configured otherwise.
+const DefaultHeartbeatInterval = 15 * time.Second
+
+// StreamEventType is the type of the server-sent events in the event stream.
+const StreamEventType string = "change"
+
+// writeEvent writes a change event as a server-sent event, with its id so the client can resume from it.
+func writeEvent(rw http.ResponseWriter, event *ChangeEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(rw, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, StreamEventType, data)
+	return err
+}
+
+// NewStreamHandler creates a HttpHandler that streams the changes to the data detected by the notification engine
+// as server-sent events, optionally only those of a country and field given by the query.
+// Clients that reconnect with a Last-Event-ID get the events they missed, as long as the broker still has them.
+// A comment is sent every heartbeat, so that idle connections are kept alive.
+func NewStreamHandler(broker *Broker, providers corona.Providers, heartbeat time.Duration) http.HandlerFunc {
+	if heartbeat <= 0 {
+		heartbeat = DefaultHeartbeatInterval
+	}
+
+	return func(rw http.ResponseWriter, r *http.Request) {
+		field := r.URL.Query().Get("field")
+		if field != "" && field != FieldStringency && field != FieldConfirmed {
+			http.Error(rw, "The field supplied does not exits", http.StatusBadRequest)
+			return
+		}
+
+		// The country is looked up, so that the client is told about typos rather than waiting forever
+		var country, code string
+		if name := r.URL.Query().Get("country"); name != "" {
+			countries, serverErr := corona.CaseCountries(providers)
+			if serverErr != nil {
+				log.Println(serverErr.Error())
+				http.Error(rw, "Something went wrong trying to look up the countries", http.StatusInternalServerError)
+				return
+			}
+			found, err := resolveCountry(countries, name)
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			country, code = found.Name, found.Alpha3Code
+		}
+
+		var after uint64
+		if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+			var err error
+			after, err = strconv.ParseUint(lastID, 10, 64)
+			if err != nil {
+				http.Error(rw, "The Last-Event-ID supplied is not valid", http.StatusBadRequest)
+				return
+			}
+		}
+
+		flusher, ok := rw.(http.Flusher)
+		if !ok {
+			log.Println("Streaming is not supported by the response writer")
+			http.Error(rw, "Streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		sub, missed := broker.Subscribe(country, code, field, after)
+		defer broker.Unsubscribe(sub)
+
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.Header().Set("Cache-Control", "no-cache")
+		rw.Header().Set("Connection", "keep-alive")
+		rw.WriteHeader(http.StatusOK)
+
+		for i := range missed {
+			if writeEvent(rw, &missed[i]) != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+				_, err := fmt.Fprint(rw, ": heartbeat\n\n")
+				if err != nil {
+					return
+				}
+			case event, open := <-sub.Events():
+				// Closed if the client fell too far behind, it can reconnect and resume from the last event it got
+				if !open {
+					return
+				}
+				if writeEvent(rw, &event) != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	}
+}
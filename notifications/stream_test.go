@@ -0,0 +1,123 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readEvent reads the next server-sent event or comment from the stream, as the lines it consists of.
+func readEvent(t *testing.T, reader *bufio.Reader) []string {
+	lines := make([]string, 0)
+	for {
+		line, err := reader.ReadString('\n')
+		if !assert.NoError(t, err) {
+			return lines
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+// TestStreamHandler tests that the stream delivers the changes published to the broker, resumes from Last-Event-ID,
+// sends heartbeats, and unsubscribes when the client disconnects.
+func TestStreamHandler(t *testing.T) {
+	fixtures, err := corona.LoadFixtures("../fixtures")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	broker := NewBroker()
+	server := httptest.NewServer(NewStreamHandler(broker, fixtures.Providers(), 50*time.Millisecond))
+	defer server.Close()
+
+	norway := exampleSnapshot("Norway", FieldConfirmed)
+	sweden := exampleSnapshot("Sweden", FieldConfirmed)
+	broker.Publish(&norway)
+	missed := broker.history[0].ID
+	broker.Publish(&norway)
+
+	// The event that was missed is delivered first, then the new ones
+	req, err := http.NewRequest(http.MethodGet, server.URL+"?country=norway&field=confirmed", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(missed, 10))
+	res, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(res.Body)
+	event := readEvent(t, reader)
+	if assert.Len(t, event, 3) {
+		assert.Equal(t, "id: "+strconv.FormatUint(missed+1, 10), event[0])
+		assert.Equal(t, "event: "+StreamEventType, event[1])
+		assert.Contains(t, event[2], `"country":"Norway"`)
+	}
+
+	assert.Eventually(t, func() bool { return broker.Subscribers() == 1 }, time.Second, 10*time.Millisecond)
+	broker.Publish(&sweden)
+	broker.Publish(&norway)
+
+	// Heartbeats are sent while there are no events, so skip any
+	for {
+		event = readEvent(t, reader)
+		if len(event) != 1 || event[0] != ": heartbeat" {
+			break
+		}
+	}
+	if assert.Len(t, event, 3) {
+		assert.Equal(t, "id: "+strconv.FormatUint(missed+3, 10), event[0])
+	}
+	assert.Equal(t, []string{": heartbeat"}, readEvent(t, reader))
+
+	res.Body.Close()
+	assert.Eventually(t, func() bool { return broker.Subscribers() == 0 }, time.Second, 10*time.Millisecond)
+
+	for _, query := range []string{"?field=deaths", "?country=Norwya"} {
+		res, err = http.Get(server.URL + query)
+		if assert.NoError(t, err) {
+			res.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, res.StatusCode, query)
+		}
+	}
+}
+
+// TestPollerFollowsStream tests that the countries and fields followed by the stream are polled,
+// even without any webhooks subscribed to them.
+func TestPollerFollowsStream(t *testing.T) {
+	fixtures, err := corona.LoadFixtures("../fixtures")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	broker := NewBroker()
+	server := httptest.NewServer(NewStreamHandler(broker, fixtures.Providers(), time.Minute))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "?country=russian%20federation")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.ElementsMatch(t, []pair{{"Russia", FieldConfirmed, "RUS"}, {"Russia", FieldStringency, "RUS"}}, broker.Follows())
+
+	store := NewMemoryStore()
+	poller := NewPoller(store, fixtures.Providers(), nil, broker, time.Minute)
+	poller.Poll()
+	for _, field := range []string{FieldConfirmed, FieldStringency} {
+		_, err = store.GetSnapshot(context.Background(), "Russia", field)
+		assert.NoError(t, err, field)
+	}
+}
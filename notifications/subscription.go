@@ -0,0 +1,252 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// pair is a country and field that a webhook is subscribed to.
+type pair struct {
+	country, field string
+	// code is the alpha3 code of the country, which stringency is looked up by. Empty if it is not known.
+	code string
+}
+
+// key identifies the pair, the same way SnapshotKey does.
+func (p pair) key() string {
+	return SnapshotKey(p.country, p.field)
+}
+
+// multi returns true if the webhook is subscribed to a list of countries or fields, rather than a single country and field.
+// Such webhooks get one combined delivery for all of them, rather than the data of a single country and field.
+func (w *Webhook) multi() bool {
+	return len(w.Countries) > 0 || len(w.Fields) > 0
+}
+
+// pairs returns all the countries and fields the webhook is subscribed to, ordered by country.
+func (w *Webhook) pairs() []pair {
+	countries, codes := w.Countries, w.CountryCodes
+	if len(countries) == 0 {
+		countries, codes = []string{w.Country}, []string{w.CountryCode}
+	}
+	fields := w.Fields
+	if len(fields) == 0 {
+		fields = []string{w.Field}
+	}
+
+	pairs := make([]pair, 0, len(countries)*len(fields))
+	for i, country := range countries {
+		// Webhooks registered before codes were looked up have none
+		code := ""
+		if i < len(codes) {
+			code = codes[i]
+		}
+		for _, field := range fields {
+			pairs = append(pairs, pair{country, field, code})
+		}
+	}
+
+	return pairs
+}
+
+// subscribedTo returns true if the webhook is subscribed to the country and field identified by key.
+func (w *Webhook) subscribedTo(key string) bool {
+	for _, p := range w.pairs() {
+		if p.key() == key {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSubscription checks that a webhook is subscribed to either a country or a list of them,
+// and either a field or a list of them.
+func validateSubscription(webhook *Webhook) error {
+	if webhook.Country != "" && (len(webhook.Countries) > 0 || webhook.Continent != "") {
+		return validationError("A webhook can have either a country, or a list of countries or a continent, not both")
+	}
+	if webhook.Field != "" && len(webhook.Fields) > 0 {
+		return validationError("A webhook can have either a field or a list of fields, not both")
+	}
+	if webhook.Country == "" && len(webhook.Countries) == 0 {
+		return validationError("A webhook needs a country, a list of countries or a continent")
+	}
+
+	seen := make(map[string]bool)
+	for _, country := range webhook.Countries {
+		if country == "" || seen[strings.ToLower(country)] {
+			return validationError("The list of countries must not contain empty names or duplicates")
+		}
+		seen[strings.ToLower(country)] = true
+	}
+
+	if len(webhook.Fields) > 0 {
+		seen = make(map[string]bool)
+		for _, field := range webhook.Fields {
+			if field != FieldStringency && field != FieldConfirmed {
+				return validationError("The field supplied does not exits")
+			}
+			if seen[field] {
+				return validationError("The list of fields must not contain duplicates")
+			}
+			seen[field] = true
+		}
+	} else if webhook.Field != FieldStringency && webhook.Field != FieldConfirmed {
+		return validationError("The field supplied does not exits")
+	}
+
+	if webhook.multi() && webhook.Condition != nil {
+		return validationError("A condition can only be used by webhooks subscribed to a single country and field")
+	}
+
+	return nil
+}
+
+// continentCountries returns the names of the countries in a continent, or a validationError if there are none.
+func continentCountries(providers corona.Providers, continent string) ([]string, error) {
+	countries, serverErr := corona.GetCountriesIn(providers, continent)
+	if serverErr != nil {
+		return nil, serverErr
+	}
+	if len(countries) == 0 {
+		return nil, validationError("The continent supplied does not exist; use Africa, Americas, Asia, Europe or Oceania")
+	}
+
+	names := make([]string, 0, len(countries))
+	for _, c := range countries {
+		names = append(names, c.Name)
+	}
+
+	return names, nil
+}
+
+// resolveCountry looks up a country among the ones there are cases for, by either of its names or its alpha3 code,
+// ignoring case.
+// An unknown country is a validationError that suggests similarly named countries.
+func resolveCountry(countries []corona.Country, name string) (*corona.Country, error) {
+	country, suggestions := corona.FindCountry(countries, name)
+	if country != nil {
+		return country, nil
+	}
+
+	message := "The country " + name + " does not exist"
+	if len(suggestions) > 0 {
+		message += "; did you mean " + strings.Join(suggestions, ", ") + "?"
+	}
+	return nil, validationError(message)
+}
+
+// resolveCountries replaces the country or countries of a webhook with the names the case provider knows them by,
+// and fills in their alpha3 codes. Empty names are left for validateSubscription to complain about.
+func resolveCountries(providers corona.Providers, webhook *Webhook) error {
+	webhook.CountryCode, webhook.CountryCodes = "", nil
+	if webhook.Country == "" && len(webhook.Countries) == 0 {
+		return nil
+	}
+
+	// The whole list is needed to suggest close matches anyway, and it is cached
+	countries, serverErr := corona.CaseCountries(providers)
+	if serverErr != nil {
+		return serverErr
+	}
+
+	if webhook.Country != "" {
+		country, err := resolveCountry(countries, webhook.Country)
+		if err != nil {
+			return err
+		}
+		webhook.Country, webhook.CountryCode = country.Name, country.Alpha3Code
+	}
+
+	if len(webhook.Countries) > 0 {
+		names := make([]string, len(webhook.Countries))
+		codes := make([]string, len(webhook.Countries))
+		for i, name := range webhook.Countries {
+			if name == "" {
+				continue
+			}
+
+			country, err := resolveCountry(countries, name)
+			if err != nil {
+				return err
+			}
+			names[i], codes[i] = country.Name, country.Alpha3Code
+		}
+		webhook.Countries, webhook.CountryCodes = names, codes
+	}
+
+	return nil
+}
+
+// writeError responds with a validationError as a bad request, and with the status of a corona.ServerError as is.
+// Returns false if err is neither, so the caller can handle it.
+func writeError(rw http.ResponseWriter, err error) bool {
+	var invalid validationError
+	var serverErr *corona.ServerError
+	if errors.As(err, &invalid) {
+		http.Error(rw, invalid.Error(), http.StatusBadRequest)
+		return true
+	} else if errors.As(err, &serverErr) {
+		http.Error(rw, serverErr.Error(), serverErr.StatusCode)
+		return true
+	}
+
+	return false
+}
+
+// countryBody is the data of one country in a combinedBody.
+type countryBody struct {
+	Country    string                  `json:"country"`
+	Confirmed  *corona.CountryResponse `json:"confirmed,omitempty"`
+	Stringency *corona.PolicyResponse  `json:"stringency,omitempty"`
+}
+
+// combinedBody is the body delivered to webhooks subscribed to several countries or fields.
+type combinedBody struct {
+	Countries []countryBody `json:"countries"`
+}
+
+// combine groups the data of several snapshots by country, keeping the countries in the order they are first seen.
+func combine(snapshots []Snapshot) *combinedBody {
+	body := &combinedBody{Countries: make([]countryBody, 0)}
+	index := make(map[string]int)
+	for i := range snapshots {
+		snapshot := &snapshots[i]
+
+		key := strings.ToLower(snapshot.Country)
+		if _, ok := index[key]; !ok {
+			index[key] = len(body.Countries)
+			body.Countries = append(body.Countries, countryBody{Country: snapshot.Country})
+		}
+
+		entry := &body.Countries[index[key]]
+		if snapshot.Field == FieldConfirmed {
+			entry.Confirmed = &snapshot.Confirmed
+		} else {
+			entry.Stringency = &snapshot.Stringency
+		}
+	}
+
+	return body
+}
+
+// gather returns the latest data seen for each of the countries and fields the webhook is subscribed to.
+// Pairs that have not been seen yet are left out.
+func gather(ctx context.Context, store WebhookStore, webhook *Webhook) ([]Snapshot, error) {
+	snapshots := make([]Snapshot, 0)
+	for _, p := range webhook.pairs() {
+		snapshot, err := store.GetSnapshot(ctx, p.country, p.field)
+		if errors.Is(err, ErrSnapshotNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		snapshots = append(snapshots, *snapshot)
+	}
+
+	return snapshots, nil
+}
@@ -0,0 +1,228 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestValidateSubscription tests that a webhook is subscribed to either a country or a list of them,
+// and either a field or a list of them.
+func TestValidateSubscription(t *testing.T) {
+	valid := []Webhook{
+		{Country: "Norway", Field: FieldConfirmed},
+		{Countries: []string{"Norway", "Sweden"}, Field: FieldStringency},
+		{Country: "Norway", Fields: []string{FieldConfirmed, FieldStringency}},
+	}
+	for i := range valid {
+		assert.NoError(t, validateSubscription(&valid[i]), i)
+	}
+
+	invalid := []Webhook{
+		{Field: FieldConfirmed},
+		{Country: "Norway", Countries: []string{"Sweden"}, Field: FieldConfirmed},
+		{Country: "Norway", Continent: "Europe", Field: FieldConfirmed},
+		{Country: "Norway", Field: FieldConfirmed, Fields: []string{FieldStringency}},
+		{Countries: []string{"Norway", "norway"}, Field: FieldConfirmed},
+		{Countries: []string{"Norway", ""}, Field: FieldConfirmed},
+		{Country: "Norway", Fields: []string{FieldConfirmed, FieldConfirmed}},
+		{Country: "Norway", Fields: []string{"deaths"}},
+		{Countries: []string{"Norway", "Sweden"}, Field: FieldConfirmed, Condition: &Condition{Metric: MetricConfirmed}},
+	}
+	for i := range invalid {
+		assert.Error(t, validateSubscription(&invalid[i]), i)
+	}
+}
+
+// TestCombine tests that snapshots are grouped by country, in the order the countries are first seen.
+func TestCombine(t *testing.T) {
+	webhook := Webhook{
+		Countries:    []string{"Norway", "Sweden"},
+		CountryCodes: []string{"NOR", "SWE"},
+		Fields:       []string{FieldConfirmed, FieldStringency},
+	}
+	assert.Equal(t, []pair{
+		{"Norway", FieldConfirmed, "NOR"}, {"Norway", FieldStringency, "NOR"},
+		{"Sweden", FieldConfirmed, "SWE"}, {"Sweden", FieldStringency, "SWE"},
+	}, webhook.pairs())
+	assert.True(t, webhook.subscribedTo(SnapshotKey("sweden", FieldStringency)))
+	assert.False(t, webhook.subscribedTo(SnapshotKey("Denmark", FieldStringency)))
+
+	body := combine([]Snapshot{
+		{Country: "Sweden", Field: FieldConfirmed, Confirmed: corona.CountryResponse{Confirmed: 200}},
+		{Country: "Norway", Field: FieldStringency, Stringency: corona.PolicyResponse{Stringency: 40}},
+		{Country: "Norway", Field: FieldConfirmed, Confirmed: corona.CountryResponse{Confirmed: 100}},
+	})
+	assert.Len(t, body.Countries, 2)
+	assert.Equal(t, "Sweden", body.Countries[0].Country)
+	assert.Equal(t, 200.0, body.Countries[0].Confirmed.Confirmed)
+	assert.Nil(t, body.Countries[0].Stringency)
+	assert.Equal(t, "Norway", body.Countries[1].Country)
+	assert.Equal(t, 100.0, body.Countries[1].Confirmed.Confirmed)
+	assert.Equal(t, 40.0, body.Countries[1].Stringency.Stringency)
+}
+
+// TestPollerCombinesDeliveries tests that a webhook subscribed to several countries gets one delivery per poll,
+// with the data of all of them, however many of them changed.
+func TestPollerCombinesDeliveries(t *testing.T) {
+	ctx := context.Background()
+
+	bodies := make(chan combinedBody, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var body combinedBody
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	store := NewMemoryStore()
+	webhook := Webhook{URL: receiver.URL, Countries: []string{"Norway", "Sweden"}, Field: FieldConfirmed, Trigger: TriggerOnChange}
+	_, err := store.Create(ctx, &webhook)
+	assert.NoError(t, err)
+
+	cases := countingCases{"Norway": 100, "Sweden": 200}
+	poller := NewPoller(store, corona.Providers{Cases: cases}, startDispatcher(t, store, DefaultDispatcherConfig), nil, time.Minute)
+
+	// First poll only establishes a baseline
+	poller.Poll()
+
+	cases["Norway"] = 150
+	cases["Sweden"] = 250
+	poller.Poll()
+
+	body := <-bodies
+	assert.Len(t, body.Countries, 2)
+	assert.Equal(t, "Norway", body.Countries[0].Country)
+	assert.Equal(t, 150.0, body.Countries[0].Confirmed.Confirmed)
+	assert.Equal(t, 250.0, body.Countries[1].Confirmed.Confirmed)
+
+	select {
+	case <-bodies:
+		t.Fatal("Both changes should be delivered together")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestResolveCountries tests that countries are stored by their canonical name and code,
+// and that unknown countries are rejected with suggestions.
+func TestResolveCountries(t *testing.T) {
+	fixtures, err := corona.LoadFixtures("../fixtures")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	providers := fixtures.Providers()
+
+	webhook := Webhook{Country: "norway", CountryCode: "XXX"}
+	assert.NoError(t, resolveCountries(providers, &webhook))
+	assert.Equal(t, "Norway", webhook.Country)
+	assert.Equal(t, "NOR", webhook.CountryCode)
+
+	webhook = Webhook{Countries: []string{"SWE", "denmark"}}
+	assert.NoError(t, resolveCountries(providers, &webhook))
+	assert.Equal(t, []string{"Sweden", "Denmark"}, webhook.Countries)
+	assert.Equal(t, []string{"SWE", "DNK"}, webhook.CountryCodes)
+
+	// The name the case provider knows the country by is kept, even if restcountries knows it by another
+	webhook = Webhook{Country: "Russian Federation", Field: FieldStringency}
+	assert.NoError(t, resolveCountries(providers, &webhook))
+	assert.Equal(t, "Russia", webhook.Country)
+	assert.Equal(t, "RUS", webhook.CountryCode)
+	for _, p := range webhook.pairs() {
+		for _, field := range []string{FieldConfirmed, FieldStringency} {
+			snapshot, serverErr := fetch(providers, p.country, p.code, field)
+			if assert.Nil(t, serverErr) {
+				assert.NotZero(t, snapshot.Confirmed.Confirmed+snapshot.Stringency.Stringency, field)
+			}
+		}
+	}
+
+	webhook = Webhook{Country: "Norwya"}
+	err = resolveCountries(providers, &webhook)
+	assert.IsType(t, validationError(""), err)
+	assert.Contains(t, err.Error(), "did you mean Norway?")
+
+	patch := webhookPatch{Countries: &[]string{"finland"}}
+	assert.NoError(t, patch.resolveCountries(providers))
+	webhook = Webhook{Country: "Norway", CountryCode: "NOR"}
+	patch.apply(&webhook)
+	assert.Equal(t, "", webhook.CountryCode)
+	assert.Equal(t, []string{"Finland"}, webhook.Countries)
+	assert.Equal(t, []string{"FIN"}, webhook.CountryCodes)
+}
+
+// TestContinentCountries tests that a continent is expanded to the countries there are cases for,
+// by the names the case provider uses.
+func TestContinentCountries(t *testing.T) {
+	fixtures, err := corona.LoadFixtures("../fixtures")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	countries, err := continentCountries(fixtures.Providers(), "europe")
+	assert.NoError(t, err)
+	assert.Contains(t, countries, "Russia")
+	assert.Contains(t, countries, "United Kingdom")
+	assert.NotContains(t, countries, "Russian Federation")
+	assert.NotContains(t, countries, "Svalbard and Jan Mayen")
+
+	webhook := Webhook{Countries: countries}
+	assert.NoError(t, resolveCountries(fixtures.Providers(), &webhook))
+	assert.Equal(t, countries, webhook.Countries)
+	assert.NotContains(t, webhook.CountryCodes, "")
+
+	_, err = continentCountries(fixtures.Providers(), "Atlantis")
+	assert.IsType(t, validationError(""), err)
+}
+
+// TestSchedulerCombinesChanges tests that when a webhook firing changes several countries,
+// the other webhooks subscribed to several of them get one delivery with all the changes, rather than one for each.
+func TestSchedulerCombinesChanges(t *testing.T) {
+	ctx := context.Background()
+
+	bodies := make(chan combinedBody, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/changes" {
+			return
+		}
+		var body combinedBody
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	store := NewMemoryStore()
+	countries := []string{"Norway", "Sweden"}
+	timeout := Webhook{URL: receiver.URL + "/timeout", Countries: countries, Field: FieldConfirmed, Trigger: TriggerOnTimeout, Timeout: 3600}
+	changes := Webhook{URL: receiver.URL + "/changes", Countries: countries, Field: FieldConfirmed, Trigger: TriggerOnChange}
+	for _, webhook := range []*Webhook{&timeout, &changes} {
+		_, err := store.Create(ctx, webhook)
+		assert.NoError(t, err)
+	}
+
+	cases := countingCases{"Norway": 100, "Sweden": 200}
+	s := NewScheduler(store, corona.Providers{Cases: cases}, startDispatcher(t, store, DefaultDispatcherConfig), nil)
+
+	// First invocation only establishes a baseline
+	s.invoke(&timeout)
+
+	cases["Norway"] = 150
+	cases["Sweden"] = 250
+	s.invoke(&timeout)
+
+	body := <-bodies
+	assert.Len(t, body.Countries, 2)
+	assert.Equal(t, 150.0, body.Countries[0].Confirmed.Confirmed)
+	assert.Equal(t, 250.0, body.Countries[1].Confirmed.Confirmed)
+
+	select {
+	case <-bodies:
+		t.Fatal("Both changes should be delivered together")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,275 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// Limits on payload templates, so that a template can not make deliveries arbitrarily expensive.
+const (
+	// MaxTemplateLength is the maximum length of a template, in bytes.
+	MaxTemplateLength int = 4096
+	// MaxRenderedLength is the maximum length of a rendered payload, in bytes.
+	MaxRenderedLength int = 64 * 1024
+)
+
+// maxFormatWidth is the largest width or precision printf accepts in templates.
+const maxFormatWidth int = 100
+
+// DefaultTemplateContentType is the content type of deliveries rendered from a template, unless the webhook says otherwise.
+const DefaultTemplateContentType string = "text/plain; charset=utf-8"
+
+// errRenderedTooLong is returned when a template renders to more than MaxRenderedLength bytes.
+var errRenderedTooLong = errors.New("the rendered payload is too long")
+
+// TemplateData is what payload templates are rendered against.
+type TemplateData struct {
+	// Webhook is the id of the webhook.
+	Webhook string
+	// Event is the id of the event, and Reason why it was delivered, one of the Reason constants.
+	Event  string
+	Reason string
+	Time   time.Time
+	// Country and Field are set for deliveries about a single country or field.
+	Country string
+	Field   string
+	// Confirmed and Stringency are the data of the first country, if the delivery is about its field.
+	Confirmed  *corona.CountryResponse
+	Stringency *corona.PolicyResponse
+	// Countries has the data of every country in the delivery, as in the combined payload.
+	Countries []countryBody
+}
+
+// templateFuncs are available to templates, in addition to the builtin functions of text/template.
+var templateFuncs = template.FuncMap{
+	// json encodes a value, for templates that build a JSON payload
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"printf": printf,
+}
+
+// printf replaces the builtin printf, refusing widths and precisions large enough to make the result arbitrarily long,
+// since fmt builds the whole result in memory before MaxRenderedLength gets to stop it.
+func printf(format string, args ...interface{}) (string, error) {
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+
+		// Go through the flags, widths, precisions and argument indexes up to the verb
+		n := 0
+		for i++; i < len(format); i++ {
+			c := format[i]
+			if c >= '0' && c <= '9' {
+				n = n*10 + int(c-'0')
+				if n > maxFormatWidth {
+					return "", fmt.Errorf("printf widths and precisions can be at most %d", maxFormatWidth)
+				}
+				continue
+			}
+			n = 0
+			if c == '*' {
+				return "", errors.New("printf widths and precisions can not be given as arguments")
+			}
+			if !strings.ContainsRune("+-# .[]", rune(c)) {
+				break
+			}
+		}
+	}
+
+	return fmt.Sprintf(format, args...), nil
+}
+
+// limitedBuffer is a buffer that refuses to grow beyond MaxRenderedLength, stopping the template that writes to it.
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > MaxRenderedLength {
+		return 0, errRenderedTooLong
+	}
+	return b.Buffer.Write(p)
+}
+
+// parseTemplate parses the payload template of a webhook, and checks that rendering it is bounded.
+func parseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("payload").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tmpl.Templates()) > 1 {
+		return nil, errors.New("templates can not define other templates")
+	}
+
+	err = checkBounded(tmpl.Tree.Root, false)
+	if err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// checkBounded returns an error if node uses a construct that can make rendering take arbitrarily long,
+// even with a payload that stays short: invoking templates, which can recurse, ranging over anything
+// but a field of the data, like an integer, or ranging within a range.
+// This way rendering takes at most as many steps as the template is long, times the number of countries.
+func checkBounded(node parse.Node, inRange bool) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			err := checkBounded(child, inRange)
+			if err != nil {
+				return err
+			}
+		}
+	case *parse.TemplateNode:
+		return errors.New("templates can not invoke other templates")
+	case *parse.IfNode:
+		return checkBranches(&n.BranchNode, inRange)
+	case *parse.WithNode:
+		return checkBranches(&n.BranchNode, inRange)
+	case *parse.RangeNode:
+		if inRange {
+			return errors.New("range can not be used within a range")
+		}
+		if !rangesOverData(n.Pipe) {
+			return errors.New("range can only be used over .Countries")
+		}
+		err := checkBounded(n.List, true)
+		if err != nil {
+			return err
+		}
+		return checkBounded(n.ElseList, inRange)
+	}
+
+	return nil
+}
+
+// checkBranches checks both branches of an if, with or range with checkBounded.
+func checkBranches(n *parse.BranchNode, inRange bool) error {
+	err := checkBounded(n.List, inRange)
+	if err != nil {
+		return err
+	}
+	return checkBounded(n.ElseList, inRange)
+}
+
+// rangesOverData returns true if the pipeline of a range is .Countries or $.Countries, the only list in the data.
+// Any other field could be a number, like .Time.UnixNano, which range counts up to.
+func rangesOverData(pipe *parse.PipeNode) bool {
+	if len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.FieldNode:
+		return len(arg.Ident) == 1 && arg.Ident[0] == "Countries"
+	case *parse.VariableNode:
+		return len(arg.Ident) == 2 && arg.Ident[0] == "$" && arg.Ident[1] == "Countries"
+	}
+	return false
+}
+
+// render renders a template against data, stopping it if the result grows too long.
+func render(tmpl *template.Template, data *TemplateData) ([]byte, error) {
+	var rendered limitedBuffer
+	err := tmpl.Execute(&rendered, data)
+	if err != nil {
+		return nil, err
+	}
+	return rendered.Bytes(), nil
+}
+
+// templateData builds the data a delivery's template is rendered against.
+func (d *Delivery) templateData() *TemplateData {
+	data := &TemplateData{
+		Webhook:   d.Webhook.ID,
+		Event:     d.Event.ID,
+		Reason:    d.Event.Reason,
+		Time:      d.Event.Time,
+		Countries: combine(d.Snapshots).Countries,
+	}
+
+	if len(data.Countries) > 0 {
+		data.Confirmed = data.Countries[0].Confirmed
+		data.Stringency = data.Countries[0].Stringency
+	}
+	if len(data.Countries) == 1 {
+		data.Country = data.Countries[0].Country
+	}
+	if len(d.Snapshots) == 1 {
+		data.Field = d.Snapshots[0].Field
+	}
+
+	return data
+}
+
+// exampleTemplateData is what templates are tried out against when they are registered.
+// It has data for both fields, so that any template that works for some delivery works for it.
+func exampleTemplateData() *TemplateData {
+	delivery := Delivery{
+		Webhook:   Webhook{ID: "example"},
+		Snapshots: []Snapshot{exampleSnapshot("Norway", FieldConfirmed), exampleSnapshot("Norway", FieldStringency)},
+		Event:     newEvent(ReasonChange),
+	}
+	data := delivery.templateData()
+	data.Field = FieldConfirmed
+	return data
+}
+
+// validateTemplate checks that the payload template of a webhook parses and renders,
+// and that it has a valid content type. A content type can not be used without a template.
+func validateTemplate(webhook *Webhook) error {
+	if webhook.Template == "" {
+		if webhook.ContentType != "" {
+			return validationError("A content type can only be used together with a template")
+		}
+		return nil
+	}
+
+	if webhook.Format == FormatCloudEvents {
+		return validationError("A webhook can have either a template or the cloudevents format, not both")
+	}
+	if len(webhook.Template) > MaxTemplateLength {
+		return validationError(fmt.Sprintf("The template must be at most %d bytes", MaxTemplateLength))
+	}
+	if webhook.ContentType != "" {
+		_, _, err := mime.ParseMediaType(webhook.ContentType)
+		if err != nil {
+			return validationError("The content type supplied is not valid")
+		}
+	}
+
+	tmpl, err := parseTemplate(webhook.Template)
+	if err != nil {
+		return validationError("The template is not valid; " + err.Error())
+	}
+	// Mistakes like misspelled fields are only found when the template is executed
+	_, err = render(tmpl, exampleTemplateData())
+	if err != nil {
+		return validationError("The template failed to render; " + err.Error())
+	}
+
+	return nil
+}
+
+// templateContentType returns the content type of deliveries rendered from the webhook's template.
+func (w *Webhook) templateContentType() string {
+	if w.ContentType == "" {
+		return DefaultTemplateContentType
+	}
+	return w.ContentType
+}
@@ -0,0 +1,92 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestValidateTemplate tests that templates are parsed and tried out when they are registered.
+func TestValidateTemplate(t *testing.T) {
+	assert.NoError(t, validateTemplate(&Webhook{}))
+	assert.NoError(t, validateTemplate(&Webhook{Template: `{"text": "{{.Country}} has {{.Confirmed.Confirmed}} cases"}`,
+		ContentType: "application/json"}))
+	assert.NoError(t, validateTemplate(&Webhook{Template: `{{range .Countries}}{{.Country}}: {{json .Confirmed}}{{end}}`}))
+	assert.NoError(t, validateTemplate(&Webhook{Template: `{{range $.Countries}}{{printf "%-10s %6.2f" .Country 1.5}}{{end}}`}))
+
+	invalid := []Webhook{
+		{ContentType: "text/plain"},
+		{Template: "{{.Country"},
+		{Template: "{{.Deaths}}"},
+		{Template: "{{.Country}}", ContentType: "not a content type;"},
+		{Template: "{{.Country}}", Format: FormatCloudEvents},
+		{Template: strings.Repeat("a", MaxTemplateLength+1)},
+	}
+	for i := range invalid {
+		assert.Error(t, validateTemplate(&invalid[i]), i)
+	}
+}
+
+// TestTemplatePayload tests that deliveries are rendered from the webhook's template, and sent with its content type.
+func TestTemplatePayload(t *testing.T) {
+	delivery := Delivery{
+		Webhook: Webhook{ID: "chat", Template: `{"text": "{{.Country}} has {{.Confirmed.Confirmed}} cases ({{.Reason}})"}`,
+			ContentType: "application/json"},
+		Snapshots: []Snapshot{{Country: "Norway", Field: FieldConfirmed, Confirmed: corona.CountryResponse{Confirmed: 100}}},
+		Event:     newEvent(ReasonChange),
+	}
+	payload, contentType, err := delivery.payload()
+	assert.NoError(t, err)
+	assert.Equal(t, "application/json", contentType)
+	assert.Equal(t, `{"text": "Norway has 100 cases (ON_CHANGE)"}`, string(payload))
+
+	delivery.Webhook.ContentType = ""
+	_, contentType, _ = delivery.payload()
+	assert.Equal(t, DefaultTemplateContentType, contentType)
+}
+
+// TestTemplateLimits tests that a template can neither render an arbitrarily long payload, nor take arbitrarily long
+// to render.
+func TestTemplateLimits(t *testing.T) {
+	tmpl, err := parseTemplate(`{{range .Countries}}{{.Country}}{{end}}`)
+	assert.NoError(t, err)
+
+	data := exampleTemplateData()
+	country := data.Countries[0]
+	country.Country = strings.Repeat("a", 1000)
+	data.Countries = nil
+	for i := 0; i < 100; i++ {
+		data.Countries = append(data.Countries, country)
+	}
+	_, err = render(tmpl, data)
+	assert.True(t, errors.Is(err, errRenderedTooLong), err)
+
+	unbounded := []string{
+		`{{range 300000000}}{{end}}ok`,
+		`{{$n := 300000000}}{{range $n}}{{end}}`,
+		`{{range len .Countries}}{{end}}`,
+		`{{range .Time.UnixNano}}{{end}}`,
+		`{{range .Time.Year}}x{{end}}`,
+		`{{range $.Time.Nanosecond}}{{end}}`,
+		`{{range .Countries}}{{range $.Countries}}{{end}}{{end}}`,
+		`{{define "forever"}}{{template "forever" .}}{{end}}{{template "forever" .}}`,
+		`{{template "payload" .}}`,
+		`{{block "more" .}}{{end}}`,
+	}
+	for _, text := range unbounded {
+		_, err = parseTemplate(text)
+		assert.Error(t, err, text)
+	}
+
+	// Padding is done in memory, before the length of the payload is checked
+	for _, text := range []string{`{{printf "%0999999999d" 1}}`, `{{printf "%.*f" 999999999 1.0}}`, `{{printf (print "%0" 200 "d") 1}}`} {
+		tmpl, err = parseTemplate(text)
+		if assert.NoError(t, err, text) {
+			_, err = render(tmpl, exampleTemplateData())
+			assert.Error(t, err, text)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// exampleSnapshot returns made up data for a field in a country, for when the real data is not wanted or available.
+func exampleSnapshot(country, field string) Snapshot {
+	snapshot := Snapshot{Country: country, Field: field, Seen: time.Now()}
+	if field == FieldConfirmed {
+		snapshot.Confirmed = corona.CountryResponse{Country: country, Scope: "total", Confirmed: 100, Recovered: 50}
+	} else {
+		snapshot.Stringency = corona.PolicyResponse{Country: country, Scope: "total", Stringency: 50}
+	}
+	return snapshot
+}
+
+// testSnapshots returns the data to send in a test delivery to the webhook: the latest data seen for each
+// of its countries and fields, fetched without saving it if it has not been seen yet, or example data if sample is set.
+// Saving freshly fetched data would make ON_CHANGE webhooks miss the change.
+func testSnapshots(r *http.Request, store WebhookStore, providers corona.Providers, webhook *Webhook, sample bool) ([]Snapshot, error) {
+	snapshots := make([]Snapshot, 0)
+	for _, p := range webhook.pairs() {
+		if sample {
+			snapshots = append(snapshots, exampleSnapshot(p.country, p.field))
+			continue
+		}
+
+		snapshot, err := store.GetSnapshot(r.Context(), p.country, p.field)
+		if errors.Is(err, ErrSnapshotNotFound) {
+			var serverErr *corona.ServerError
+			snapshot, serverErr = fetch(providers, p.country, p.code, p.field)
+			if serverErr != nil {
+				return nil, serverErr
+			}
+		} else if err != nil {
+			return nil, err
+		}
+
+		snapshots = append(snapshots, *snapshot)
+	}
+
+	return snapshots, nil
+}
+
+// NewTestHandler creates a HttpHandler that, given a webhook id, sends a delivery to the webhook right away,
+// and responds with the outcome. The delivery is made with the current data, or example data if the sample query
+// parameter is true. It is recorded in the delivery history, but does not count as an invocation,
+// and does not affect when the webhook is next invoked.
+func NewTestHandler(store WebhookStore, providers corona.Providers, dispatcher *Dispatcher) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		webhook, err := store.Get(r.Context(), id)
+		if errors.Is(err, ErrWebhookNotFound) {
+			http.Error(rw, "Invalid webhook id; No webhook registered by that id", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Println(err.Error())
+			http.Error(rw, "Something went wrong trying to get the webhook", http.StatusInternalServerError)
+			return
+		}
+
+		// Testing would otherwise be a way around verifying the receiver
+		if webhook.State == StatePending {
+			http.Error(rw, "The webhook has not been verified, and can not be tested until it is", http.StatusConflict)
+			return
+		}
+
+		snapshots, err := testSnapshots(r, store, providers, webhook, r.URL.Query().Get("sample") == "true")
+		if err != nil {
+			if !writeError(rw, err) {
+				log.Println(err.Error())
+				http.Error(rw, "Something went wrong trying to get the data", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		delivery := Delivery{Webhook: *webhook, Snapshots: snapshots, Reason: ReasonTest, Event: newEvent(ReasonTest), Attempts: 1}
+		record := DeliveryRecord{WebhookID: id, Time: time.Now(), Reason: ReasonTest, Attempt: 1}
+		failure := dispatcher.post(&delivery, &record)
+		if failure != nil {
+			record.Error = failure.Error()
+		}
+
+		err = store.AddDelivery(r.Context(), &record)
+		if err != nil {
+			log.Println("Failed to record test delivery to webhook", id, err.Error())
+		}
+
+		_ = json.NewEncoder(rw).Encode(record)
+	}
+}
@@ -0,0 +1,84 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTestHandler tests that a test delivery is sent right away, and reported back,
+// without affecting when the webhook is next invoked or what ON_CHANGE webhooks compare against.
+func TestTestHandler(t *testing.T) {
+	bodies := make(chan corona.CountryResponse, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var body corona.CountryResponse
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		bodies <- body
+		if r.URL.Path == "/failing" {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+
+	ctx := context.Background()
+	store := NewMemoryStore()
+	last := time.Now().Add(-time.Hour).Round(time.Second)
+	webhook := Webhook{URL: receiver.URL, Timeout: 3600, Country: "Norway", Field: FieldConfirmed, Trigger: TriggerOnChange,
+		LastTriggered: last, State: StateActive}
+	id, _ := store.Create(ctx, &webhook)
+	webhook.URL, webhook.State = receiver.URL+"/failing", StateActive
+	failing, _ := store.Create(ctx, &webhook)
+	webhook.State = StatePending
+	pending, _ := store.Create(ctx, &webhook)
+
+	providers := corona.Providers{Cases: countingCases{"Norway": 100}}
+	r := chi.NewRouter()
+	r.Post(IDPattern+TestPath, NewTestHandler(store, providers, NewDispatcher(store, DispatcherConfig{Timeout: time.Second})))
+	test := func(path string) (int, DeliveryRecord) {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, path, nil))
+
+		var record DeliveryRecord
+		_ = json.NewDecoder(rw.Body).Decode(&record)
+		return rw.Code, record
+	}
+
+	code, record := test("/" + id + TestPath)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, http.StatusOK, record.StatusCode)
+	assert.Equal(t, ReasonTest, record.Reason)
+	assert.Empty(t, record.Error)
+	assert.Equal(t, 100.0, (<-bodies).Confirmed, "The current data should be delivered")
+
+	stored, _ := store.Get(ctx, id)
+	assert.True(t, last.Equal(stored.LastTriggered), "A test should not affect LastTriggered")
+	assert.Equal(t, 0, stored.Invocations)
+	_, err := store.GetSnapshot(ctx, "Norway", FieldConfirmed)
+	assert.ErrorIs(t, err, ErrSnapshotNotFound, "Data fetched for a test should not be saved")
+	_, total, _ := store.ListDeliveries(ctx, id, 0, DeliveryHistoryLimit)
+	assert.Equal(t, 1, total, "A test should be recorded in the history")
+
+	code, _ = test("/" + id + TestPath + "?sample=true")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, exampleSnapshot("Norway", FieldConfirmed).Confirmed, <-bodies)
+
+	code, record = test("/" + failing + TestPath)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, http.StatusInternalServerError, record.StatusCode)
+	assert.NotEmpty(t, record.Error)
+	<-bodies
+	letters, _ := store.ListDeadLetters(ctx)
+	assert.Empty(t, letters, "A failed test should not be retried")
+
+	code, _ = test("/" + pending + TestPath)
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = test("/unknown" + TestPath)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
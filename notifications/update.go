@@ -0,0 +1,222 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// webhookPatch is the body of a request to update a webhook. Fields that are left out are left unchanged.
+type webhookPatch struct {
+	URL       *string    `json:"url"`
+	Timeout   *int       `json:"timeout"`
+	Schedule  *string    `json:"schedule"`
+	Timezone  *string    `json:"timezone"`
+	Field     *string    `json:"field"`
+	Fields    *[]string  `json:"fields"`
+	Country   *string    `json:"country"`
+	Countries *[]string  `json:"countries"`
+	Continent *string    `json:"continent"`
+	Trigger   *string    `json:"trigger"`
+	Condition *Condition `json:"condition"`
+	Format    *string    `json:"format"`
+
+	Template    *string `json:"template"`
+	ContentType *string `json:"content_type"`
+
+	Expires        *time.Time `json:"expires"`
+	MaxInvocations *int       `json:"max_invocations"`
+
+	// The alpha3 codes of the countries, looked up by resolveCountries rather than given by the client.
+	countryCode  string
+	countryCodes []string
+}
+
+// resolveCountries looks up the country or countries in the patch, the same way as for a new webhook.
+func (p *webhookPatch) resolveCountries(providers corona.Providers) error {
+	var resolved Webhook
+	if p.Country != nil {
+		resolved.Country = *p.Country
+	}
+	if p.Countries != nil {
+		resolved.Countries = *p.Countries
+	}
+
+	err := resolveCountries(providers, &resolved)
+	if err != nil {
+		return err
+	}
+
+	if p.Country != nil {
+		p.Country, p.countryCode = &resolved.Country, resolved.CountryCode
+	}
+	if p.Countries != nil {
+		p.Countries, p.countryCodes = &resolved.Countries, resolved.CountryCodes
+	}
+	return nil
+}
+
+// apply sets the fields of the webhook that are present in the patch.
+func (p *webhookPatch) apply(webhook *Webhook) {
+	if p.URL != nil {
+		// A new receiver has to be verified before it gets any deliveries, which does not resume a paused webhook
+		if *p.URL != webhook.URL {
+			webhook.Paused = webhook.State == StatePaused || (webhook.State == StatePending && webhook.Paused)
+			webhook.State = StatePending
+		}
+		webhook.URL = *p.URL
+	}
+	// A webhook has either a timeout or a schedule, so setting one replaces the other
+	if p.Timeout != nil {
+		webhook.Timeout = *p.Timeout
+		if p.Schedule == nil {
+			webhook.Schedule, webhook.Timezone = "", ""
+		}
+	}
+	if p.Schedule != nil {
+		webhook.Schedule = *p.Schedule
+		if p.Timeout == nil {
+			webhook.Timeout = 0
+		}
+	}
+	if p.Timezone != nil {
+		webhook.Timezone = *p.Timezone
+	}
+	// A webhook has either a single country and field, or lists of them, so setting one replaces the other
+	if p.Field != nil {
+		webhook.Field, webhook.Fields = *p.Field, nil
+	}
+	if p.Fields != nil {
+		webhook.Field, webhook.Fields = "", *p.Fields
+	}
+	if p.Country != nil {
+		webhook.Country, webhook.Countries, webhook.Continent = *p.Country, nil, ""
+		webhook.CountryCode, webhook.CountryCodes = p.countryCode, nil
+	}
+	if p.Countries != nil {
+		webhook.Country, webhook.Countries, webhook.Continent = "", *p.Countries, ""
+		webhook.CountryCode, webhook.CountryCodes = "", p.countryCodes
+	}
+	if p.Continent != nil {
+		// The countries in the continent are looked up by the handler, before the patch is applied
+		webhook.Country, webhook.Continent = "", *p.Continent
+	}
+	if p.Trigger != nil {
+		webhook.Trigger = *p.Trigger
+		if webhook.Trigger != TriggerOnCondition {
+			webhook.Condition = nil // Only ON_CONDITION webhooks have a condition
+		}
+	}
+	if p.Condition != nil {
+		// A new condition starts over, it has not seen any data yet
+		condition := *p.Condition
+		condition.Reference = nil
+		webhook.Condition = &condition
+	}
+	if p.Format != nil {
+		webhook.Format = *p.Format
+	}
+	if p.Template != nil {
+		webhook.Template = *p.Template
+	}
+	if p.ContentType != nil {
+		webhook.ContentType = *p.ContentType
+	}
+	if p.Expires != nil {
+		webhook.Expires = p.Expires
+	}
+	if p.MaxInvocations != nil {
+		webhook.MaxInvocations = *p.MaxInvocations
+	}
+}
+
+// NewUpdateHandler creates a HttpHandler that, given a webhook id, changes some of the fields of the webhook,
+// keeping its id, secret and history. The result is validated the same way as a new webhook.
+// Requests to the receiver are limited by egress, which allows anything if nil.
+func NewUpdateHandler(store WebhookStore, providers corona.Providers, scheduler *Scheduler, egress *EgressPolicy) http.HandlerFunc {
+	client := egress.Client(VerificationTimeout)
+	return func(rw http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		// Only the fields in the patch can be changed, anything else is a mistake we should tell the client about
+		var patch webhookPatch
+		decoder := json.NewDecoder(http.MaxBytesReader(rw, r.Body, MaxBodyLength))
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&patch)
+		if err != nil {
+			http.Error(rw, "Failed to parse request body; the id, secret, state and history of a webhook can not be updated",
+				http.StatusBadRequest)
+			return
+		}
+
+		err = validateExpires(patch.Expires)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if patch.Continent != nil {
+			var countries []string
+			countries, err = continentCountries(providers, *patch.Continent)
+			patch.Countries = &countries
+		}
+		if err == nil {
+			err = patch.resolveCountries(providers)
+		}
+		if err != nil {
+			if !writeError(rw, err) {
+				log.Println(err.Error())
+				http.Error(rw, "Something went wrong trying to look up the countries", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// Only check the url if it changed, so a receiver being down does not stop its other fields from being updated
+		if patch.URL != nil {
+			err = validateURL(egress, client, *patch.URL)
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		webhook, err := store.Update(r.Context(), id, func(webhook *Webhook) error {
+			patch.apply(webhook)
+			return validate(webhook)
+		})
+		var invalid validationError
+		if errors.As(err, &invalid) {
+			http.Error(rw, invalid.Error(), http.StatusBadRequest)
+			return
+		} else if errors.Is(err, ErrWebhookNotFound) {
+			http.Error(rw, "Invalid webhook id; No webhook registered by that id", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Println(err.Error())
+			http.Error(rw, "Something went wrong trying to update the webhook", http.StatusInternalServerError)
+			return
+		}
+
+		// Notify the scheduler, so the new timeout or schedule takes effect right away
+		scheduler.Schedule(webhook)
+
+		// A webhook with a new url is pending until its receiver passes the challenge, which activates it again.
+		// If it fails, the webhook is shown as pending, and can be verified again later.
+		if webhook.State == StatePending && patch.URL != nil {
+			var verified *Webhook
+			verified, err = verify(r.Context(), store, scheduler, client, webhook)
+			if err == nil {
+				webhook = verified
+			}
+		}
+
+		log.Println("Updated webhook:", id)
+		webhook.display(time.Now())
+		_ = json.NewEncoder(rw).Encode(webhook)
+	}
+}
@@ -0,0 +1,124 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestUpdateHandler tests that updates are validated, and that a shorter timeout is picked up by the scheduler right away.
+func TestUpdateHandler(t *testing.T) {
+	delivered := make(chan string, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && !echoChallenge(rw, r) {
+			delivered <- r.URL.Path
+		}
+	}))
+	defer receiver.Close()
+
+	store := NewMemoryStore()
+	providers := corona.Providers{Cases: countingCases{"Norway": 100}}
+	s := NewScheduler(store, providers, startDispatcher(t, store, DefaultDispatcherConfig), nil)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go s.Run(wg)
+	defer func() {
+		s.Stop()
+		wg.Wait()
+	}()
+
+	id, _ := store.Create(context.Background(), &Webhook{
+		URL:           receiver.URL + "/old",
+		Timeout:       3600,
+		Country:       "Norway",
+		Field:         FieldConfirmed,
+		Trigger:       TriggerOnTimeout,
+		LastTriggered: time.Now().Add(-time.Minute),
+		Secret:        "secret",
+	})
+
+	r := chi.NewRouter()
+	r.Patch(IDPattern, NewUpdateHandler(store, corona.Providers{}, s, nil))
+	patch := func(id, body string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest(http.MethodPatch, "/"+id, strings.NewReader(body)))
+		return rw
+	}
+
+	assert.Equal(t, http.StatusBadRequest, patch(id, `{"field": "deaths"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, patch(id, `{"timeout": 0}`).Code)
+	assert.Equal(t, http.StatusBadRequest, patch(id, `{"secret": "mine"}`).Code, "Only the listed fields can be updated")
+	assert.Equal(t, http.StatusBadRequest, patch("unknown", `{"timeout": 30}`).Code)
+	huge := `{"country": "` + strings.Repeat("a", int(MaxBodyLength)) + `"}`
+	assert.Equal(t, http.StatusBadRequest, patch(id, huge).Code, "Bodies longer than MaxBodyLength should be rejected")
+
+	webhook, _ := store.Get(context.Background(), id)
+	assert.Equal(t, 3600, webhook.Timeout, "A rejected update should not change anything")
+	assert.Equal(t, FieldConfirmed, webhook.Field)
+
+	rw := patch(id, `{"url": "`+receiver.URL+`/new", "timeout": 30}`)
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.NotContains(t, rw.Body.String(), "secret")
+
+	// The webhook was last triggered a minute ago, so with a 30 second timeout it is already due
+	select {
+	case path := <-delivered:
+		assert.Equal(t, "/new", path)
+	case <-time.After(time.Second):
+		t.Fatal("The updated webhook was not invoked")
+	}
+
+	webhook, _ = store.Get(context.Background(), id)
+	assert.Equal(t, id, webhook.ID)
+	assert.Equal(t, 30, webhook.Timeout)
+	assert.Equal(t, "secret", webhook.Secret, "An update should keep the secret")
+}
+
+// TestUpdatePausedURL tests that a paused webhook whose url changes is still paused once the new url is verified,
+// rather than being resumed behind the client's back.
+func TestUpdatePausedURL(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		echoChallenge(rw, r)
+	}))
+	defer receiver.Close()
+
+	store := NewMemoryStore()
+	id, _ := store.Create(context.Background(), &Webhook{
+		URL: receiver.URL + "/old", Timeout: 3600, Country: "Norway", Field: FieldConfirmed, Trigger: TriggerOnTimeout,
+		State: StatePaused,
+	})
+	scheduler := NewScheduler(store, corona.Providers{}, nil, nil)
+
+	r := chi.NewRouter()
+	r.Patch(IDPattern, NewUpdateHandler(store, corona.Providers{}, scheduler, nil))
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodPatch, "/"+id, strings.NewReader(`{"url": "`+receiver.URL+`/new"}`)))
+	assert.Equal(t, http.StatusOK, rw.Code, rw.Body.String())
+
+	webhook, _ := store.Get(context.Background(), id)
+	assert.Equal(t, StatePaused, webhook.State)
+	assert.False(t, webhook.Paused)
+	for len(scheduler.events) > 0 {
+		event := <-scheduler.events
+		assert.False(t, event.webhook.Active(time.Now()), "The scheduler should never be told the webhook is active")
+	}
+
+	// Until the new url is verified, the webhook is pending, and remembers that it was paused
+	old, older := receiver.URL+"/old", receiver.URL+"/older"
+	patch := webhookPatch{URL: &old}
+	patch.apply(webhook)
+	assert.Equal(t, StatePending, webhook.State)
+	assert.True(t, webhook.Paused)
+	patch.URL = &older
+	patch.apply(webhook)
+	assert.True(t, webhook.Paused, "Changing the url of a pending webhook again should not forget that it was paused")
+}
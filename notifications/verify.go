@@ -0,0 +1,170 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// VerificationTimeout is how long a receiver has to answer the verification challenge.
+const VerificationTimeout = 10 * time.Second
+
+// ChallengeType is the type of the body posted to a receiver to verify it.
+const ChallengeType string = "verification"
+
+// challengeLength is the number of random bytes in a challenge token.
+const challengeLength int = 16
+
+// maxChallengeResponse is how much of the receiver's response to the challenge is read, in bytes.
+const maxChallengeResponse int64 = 1024
+
+// challengeBody is posted to the receiver of a webhook to verify that it wants the deliveries.
+// The receiver proves it by echoing the challenge back.
+type challengeBody struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+}
+
+// challengeError is why a receiver failed the verification challenge.
+type challengeError struct {
+	err error
+}
+
+func (e *challengeError) Error() string {
+	return "The receiver failed the verification challenge; " + e.err.Error()
+}
+
+func (e *challengeError) Unwrap() error {
+	return e.err
+}
+
+// challenge posts a random token to the receiver of the webhook using client, and checks that the receiver echoes it
+// back, either as the challenge of a JSON body like the one it was sent, or as the whole body.
+func challenge(client *http.Client, webhook *Webhook) error {
+	token := randomHex(challengeLength)
+	payload := new(bytes.Buffer)
+	_ = json.NewEncoder(payload).Encode(challengeBody{ChallengeType, token})
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Signed like any other delivery, so the receiver can tell the challenge came from us
+	webhook.sign(req, payload.Bytes(), time.Now())
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if !corona.StatusIs2XX(res.StatusCode) {
+		return fmt.Errorf("receiver responded with non 2XX code %d", res.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxChallengeResponse))
+	if err != nil {
+		return err
+	}
+
+	var echo challengeBody
+	if json.Unmarshal(body, &echo) == nil && echo.Challenge == token {
+		return nil
+	}
+	if strings.TrimSpace(string(body)) == token {
+		return nil
+	}
+
+	return errors.New("receiver did not echo the challenge")
+}
+
+// activate makes a pending webhook active, or paused again if it was paused, now that url has been verified,
+// and tells the scheduler about it.
+// The webhook is left as is if it is no longer pending, or its url has changed since the challenge was sent.
+func activate(ctx context.Context, store WebhookStore, scheduler *Scheduler, id, url string) (*Webhook, error) {
+	now := time.Now()
+	webhook, err := store.Update(ctx, id, func(webhook *Webhook) error {
+		if webhook.State != StatePending || webhook.URL != url {
+			return nil
+		}
+
+		webhook.State = StateActive
+		if webhook.Paused {
+			webhook.State = StatePaused
+		}
+		webhook.Paused = false
+		// It may have expired while it waited
+		webhook.refreshState(now)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	scheduler.Schedule(webhook)
+	return webhook, nil
+}
+
+// verify challenges the receiver of a pending webhook, and activates the webhook if it passes.
+// Returns the webhook as it is afterwards, or a *challengeError if the receiver failed the challenge.
+func verify(ctx context.Context, store WebhookStore, scheduler *Scheduler, client *http.Client, webhook *Webhook) (*Webhook, error) {
+	err := challenge(client, webhook)
+	if err != nil {
+		log.Println("Webhook", webhook.ID, "failed verification:", err.Error())
+		return webhook, &challengeError{err}
+	}
+
+	log.Println("Webhook", webhook.ID, "verified")
+	return activate(ctx, store, scheduler, webhook.ID, webhook.URL)
+}
+
+// NewVerifyHandler creates a HttpHandler that, given a webhook id, challenges the receiver of a pending webhook again,
+// and activates the webhook if it passes. Requests to the receiver are limited by egress, which allows anything if nil.
+func NewVerifyHandler(store WebhookStore, scheduler *Scheduler, egress *EgressPolicy) http.HandlerFunc {
+	client := egress.Client(VerificationTimeout)
+	return func(rw http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+
+		webhook, err := store.Get(r.Context(), id)
+		if errors.Is(err, ErrWebhookNotFound) {
+			http.Error(rw, "Invalid webhook id; No webhook registered by that id", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Println(err.Error())
+			http.Error(rw, "Something went wrong trying to get the webhook", http.StatusInternalServerError)
+			return
+		}
+
+		if webhook.State != StatePending {
+			http.Error(rw, "The webhook has already been verified", http.StatusConflict)
+			return
+		}
+
+		webhook, err = verify(r.Context(), store, scheduler, client, webhook)
+		var failed *challengeError
+		if errors.As(err, &failed) {
+			http.Error(rw, failed.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Println(err.Error())
+			http.Error(rw, "Something went wrong trying to activate the webhook", http.StatusInternalServerError)
+			return
+		}
+
+		webhook.display(time.Now())
+		_ = json.NewEncoder(rw).Encode(webhook)
+	}
+}
@@ -0,0 +1,117 @@
+package notifications
+
+import (
+	"assignment-2/corona"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+)
+
+// echoChallenge answers the verification challenge like a well behaved receiver, if r is one.
+// Returns false, and leaves the body to be read again, if r is a delivery.
+func echoChallenge(rw http.ResponseWriter, r *http.Request) bool {
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var challenge challengeBody
+	if json.Unmarshal(body, &challenge) != nil || challenge.Type != ChallengeType {
+		return false
+	}
+
+	_ = json.NewEncoder(rw).Encode(challengeBody{Challenge: challenge.Challenge})
+	return true
+}
+
+// TestChallenge tests that only receivers that echo the challenge pass it.
+func TestChallenge(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			echoChallenge(rw, r)
+		case "/plain":
+			var challenge challengeBody
+			_ = json.NewDecoder(r.Body).Decode(&challenge)
+			_, _ = rw.Write([]byte(challenge.Challenge + "\n"))
+		case "/wrong":
+			_, _ = rw.Write([]byte(`{"challenge": "something else"}`))
+		case "/failing":
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+
+	assert.NoError(t, challenge(http.DefaultClient, &Webhook{URL: receiver.URL + "/json"}))
+	assert.NoError(t, challenge(http.DefaultClient, &Webhook{URL: receiver.URL + "/plain"}))
+	assert.Error(t, challenge(http.DefaultClient, &Webhook{URL: receiver.URL + "/wrong"}))
+	assert.Error(t, challenge(http.DefaultClient, &Webhook{URL: receiver.URL + "/ignoring"}), "Replying without the challenge is not enough")
+	assert.Error(t, challenge(http.DefaultClient, &Webhook{URL: receiver.URL + "/failing"}))
+}
+
+// TestCreatePending tests that a webhook whose receiver fails the challenge is kept pending, and not invoked,
+// until it is verified.
+func TestCreatePending(t *testing.T) {
+	var echo int32 // Set to 1 once the receiver should answer the challenge
+	delivered := make(chan struct{}, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			return
+		}
+		if atomic.LoadInt32(&echo) == 1 && echoChallenge(rw, r) {
+			return
+		}
+		delivered <- struct{}{}
+	}))
+	defer receiver.Close()
+
+	fixtures, err := corona.LoadFixtures("../fixtures")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	store := NewMemoryStore()
+	scheduler := NewScheduler(store, fixtures.Providers(), nil, nil)
+
+	r := chi.NewRouter()
+	r.Post("/", NewCreateHandler(store, fixtures.Providers(), scheduler, nil))
+	r.Post(IDPattern+VerifyPath, NewVerifyHandler(store, scheduler, nil))
+
+	rw := httptest.NewRecorder()
+	body := `{"url": "` + receiver.URL + `", "timeout": 60, "country": "Norway", "field": "confirmed", "trigger": "ON_TIMEOUT"}`
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	assert.Equal(t, http.StatusCreated, rw.Code)
+
+	var response responseBody
+	_ = json.NewDecoder(rw.Body).Decode(&response)
+	assert.Equal(t, StatePending, response.State)
+	assert.NotEmpty(t, response.Verification)
+	<-delivered // The challenge itself
+
+	webhook, _ := store.Get(context.Background(), response.ID)
+	assert.Equal(t, StatePending, webhook.State)
+	assert.False(t, webhook.Active(time.Now()), "A pending webhook should never be invoked")
+	assert.Empty(t, scheduler.events, "A pending webhook should not be scheduled")
+
+	// Verify it once the receiver knows how to answer
+	atomic.StoreInt32(&echo, 1)
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/"+response.ID+VerifyPath, nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	webhook, _ = store.Get(context.Background(), response.ID)
+	assert.Equal(t, StateActive, webhook.State)
+	event := <-scheduler.events
+	assert.Equal(t, response.ID, event.webhook.ID)
+
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/"+response.ID+VerifyPath, nil))
+	assert.Equal(t, http.StatusConflict, rw.Code, "An active webhook should not be verified again")
+	assert.Empty(t, delivered)
+}